@@ -0,0 +1,52 @@
+package rego2sql
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+)
+
+// qualifyColumns rewrites the column references of the tree according to the
+// TableAlias, Schema and TableAliases of the config. The tree is copied
+// first, as matchers are free to reuse nodes.
+func qualifyColumns(cfg ConvertConfig, n *pg_query.Node) *pg_query.Node {
+	if cfg.TableAlias == "" && len(cfg.TableAliases) == 0 {
+		return n
+	}
+
+	var prefix []*pg_query.Node
+	if cfg.TableAlias != "" {
+		if cfg.Schema != "" {
+			prefix = append(prefix, pg_query.MakeStrNode(cfg.Schema))
+		}
+		prefix = append(prefix, pg_query.MakeStrNode(cfg.TableAlias))
+	}
+
+	n = proto.Clone(n).(*pg_query.Node)
+	WalkNodes(n, func(n *pg_query.Node) {
+		ref := n.GetColumnRef()
+		if ref == nil || len(ref.Fields) == 0 {
+			return
+		}
+
+		if len(ref.Fields) == 1 {
+			if prefix == nil || ref.Fields[0].GetAStar() != nil {
+				return
+			}
+			fields := make([]*pg_query.Node, 0, len(prefix)+1)
+			for _, p := range prefix {
+				fields = append(fields, proto.Clone(p).(*pg_query.Node))
+			}
+			ref.Fields = append(fields, ref.Fields...)
+			return
+		}
+
+		table := ref.Fields[0].GetString_()
+		if table == nil {
+			return
+		}
+		if alias, ok := cfg.TableAliases[table.Sval]; ok {
+			ref.Fields[0] = pg_query.MakeStrNode(alias)
+		}
+	})
+	return n
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Emyrk/rego2sql/coverage"
+)
+
+func runCoverage(args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	var opts options
+	opts.register(fs)
+	query := fs.String("query", "data.authz.allow = true", "Rego query to partially evaluate.")
+	format := fs.String("format", "text", "Output format, text or json.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rego2sql coverage [flags] [input files or directories...]\n\n"+
+			"Partially evaluates the policy for each JSON input, and reports the expressions\n"+
+			"that cannot be converted. It exits with 1 if there are any.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected text or json", *format)
+	}
+
+	partial, err := opts.partialConfig()
+	if err != nil {
+		return err
+	}
+	partial.Query = *query
+	cfg, err := loadMapping(opts.mapping)
+	if err != nil {
+		return fmt.Errorf("mapping: %w", err)
+	}
+
+	inputs, err := loadInputs(fs.Args())
+	if err != nil {
+		return fmt.Errorf("load inputs: %w", err)
+	}
+	if partial.Input != nil {
+		inputs = append([]any{partial.Input}, inputs...)
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no inputs, give the input files as arguments")
+	}
+
+	report, err := coverage.Analyze(context.Background(), coverage.Config{
+		Partial: partial,
+		Convert: cfg,
+		Inputs:  inputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.Write(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Unconvertible > 0 || report.SupportModules > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// loadInputs reads the JSON files, searching directories for '.json' files.
+// The files of a directory are sorted, so the input indexes are stable.
+func loadInputs(paths []string) ([]any, error) {
+	var files []string
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (path != root && filepath.Ext(path) != ".json") {
+				return nil
+			}
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+
+	inputs := make([]any, 0, len(files))
+	for _, file := range files {
+		input, err := loadInput(file)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
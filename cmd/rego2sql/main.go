@@ -1,38 +1,217 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/mapping"
 	"github.com/open-policy-agent/opa/v1/ast"
 )
 
+// listFlag is a flag that can be repeated, or given a comma separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// options are the flags shared by the commands.
+type options struct {
+	policies  listFlag
+	unknowns  listFlag
+	inputPath string
+	mapping   string
+	v0        bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.Var(&o.policies, "policy", "Rego policy file or directory, can be repeated.")
+	fs.StringVar(&o.inputPath, "input", "", "JSON file with the known input.")
+	fs.Var(&o.unknowns, "unknowns", "Refs left unknown during partial evaluation, can be repeated. (default input)")
+	fs.StringVar(&o.mapping, "mapping", "", fmt.Sprintf("JSON mapping file, or one of the presets: %s.", strings.Join(mapping.PresetNames(), ", ")))
+	fs.BoolVar(&o.v0, "v0-compatible", false, "Parse the policies as rego v0.")
+}
+
+// partialConfig loads the policies and input.
+func (o *options) partialConfig() (rego2sql.PartialConfig, error) {
+	partial := rego2sql.PartialConfig{
+		Unknowns: o.unknowns,
+	}
+	if o.v0 {
+		partial.RegoVersion = ast.RegoV0
+	}
+
+	var err error
+	partial.Modules, err = loadPolicies(o.policies)
+	if err != nil {
+		return rego2sql.PartialConfig{}, fmt.Errorf("load policies: %w", err)
+	}
+	if o.inputPath != "" {
+		partial.Input, err = loadInput(o.inputPath)
+		if err != nil {
+			return rego2sql.PartialConfig{}, fmt.Errorf("load input: %w", err)
+		}
+	}
+	return partial, nil
+}
+
 func main() {
 	log.SetOutput(os.Stderr)
+
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "repl":
+			run = runREPL
+		case "serve":
+			run = runServe
+		case "coverage":
+			run = runCoverage
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err.Error())
+			}
+			return
+		}
+	}
+
+	var opts options
+	opts.register(flag.CommandLine)
+	query := flag.String("query", "", "Rego query to partially evaluate, such as 'data.authz.allow = true'. Without it, the arguments are converted as rego query bodies.")
+	format := flag.String("format", "text", fmt.Sprintf("Output format, one of: %s.", strings.Join(formats, ", ")))
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: rego2sql [flags] [query bodies...]\n       rego2sql repl [flags]\n       rego2sql serve [flags]\n       rego2sql coverage [flags] [inputs...]\n\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	bodies := make([]ast.Body, 0)
-	for _, arg := range flag.Args() {
-		body, err := ast.ParseBody(arg)
+	cfg, err := loadMapping(opts.mapping)
+	if err != nil {
+		log.Fatal(fmt.Errorf("mapping: %w", err).Error())
+	}
+
+	var bodies []ast.Body
+	if *query != "" {
+		if flag.NArg() > 0 {
+			log.Fatal("query bodies cannot be given as arguments with --query")
+		}
+
+		partial, err := opts.partialConfig()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		partial.Query = *query
+
+		bodies, err = rego2sql.Partial(context.Background(), partial)
 		if err != nil {
-			log.Fatal(fmt.Errorf("parse body %s: %w", arg, err).Error())
+			log.Fatal(err.Error())
+		}
+	} else {
+		for _, arg := range flag.Args() {
+			body, err := ast.ParseBody(arg)
+			if err != nil {
+				log.Fatal(fmt.Errorf("parse body %s: %w", arg, err).Error())
+			}
+			bodies = append(bodies, body)
 		}
-		bodies = append(bodies, body)
 	}
 
-	sqlNode, err := rego2sql.Convert(rego2sql.ConvertConfig{}, bodies)
+	if *format == "explain" {
+		// The explanation is a tree of the queries, so it is not written
+		// with the other formats.
+		_, explanation, err := rego2sql.Explain(cfg, bodies)
+		if err != nil {
+			log.Fatal(fmt.Errorf("convert: %w", err).Error())
+		}
+		if err := explanation.Write(os.Stdout); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	sqlNode, diags, err := rego2sql.ConvertWithDiagnostics(cfg, bodies)
 	if err != nil {
 		log.Fatal(fmt.Errorf("convert: %w", err).Error())
 	}
 
-	output, err := rego2sql.Serialize(sqlNode)
+	if err := writeOutput(os.Stdout, os.Stderr, *format, bodies, sqlNode, diags); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// loadMapping loads a mapping file, or a preset by name. An empty config is
+// returned if neither is given.
+func loadMapping(name string) (rego2sql.ConvertConfig, error) {
+	if name == "" {
+		return rego2sql.ConvertConfig{}, nil
+	}
+
+	m := &mapping.Mapping{Preset: name}
+	if _, ok := mapping.Presets[name]; !ok {
+		var err error
+		m, err = mapping.Load(name)
+		if err != nil {
+			return rego2sql.ConvertConfig{}, err
+		}
+	}
+	return m.ConvertConfig()
+}
+
+// loadPolicies reads the rego files, searching directories recursively. Test
+// files are skipped.
+func loadPolicies(paths []string) (map[string]string, error) {
+	modules := make(map[string]string)
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Files given explicitly are always loaded.
+			if d.IsDir() || (path != root && (filepath.Ext(path) != ".rego" || strings.HasSuffix(path, "_test.rego"))) {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			modules[path] = string(data)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return modules, nil
+}
+
+func loadInput(path string) (any, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal(fmt.Errorf("serialize: %w", err).Error())
+		return nil, err
 	}
 
-	fmt.Println("PGSQL:")
-	fmt.Println(output)
+	var input any
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return input, nil
 }
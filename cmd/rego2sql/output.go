@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var formats = []string{"text", "json", "sql-params", "ast", "explain"}
+
+// jsonOutput is the output of the json format.
+type jsonOutput struct {
+	SQL string `json:"sql"`
+	// SQLParams is the SQL with the constants as parameters.
+	SQLParams string             `json:"sql_params"`
+	Args      []any              `json:"args"`
+	Queries   []string           `json:"queries"`
+	Dropped   []rego2sql.Dropped `json:"dropped"`
+	Warnings  []string           `json:"warnings"`
+	Matches   []rego2sql.Match   `json:"matches"`
+}
+
+// writeOutput writes the converted node in the format. Warnings are written
+// to stderr for the formats that cannot include them.
+func writeOutput(stdout, stderr io.Writer, format string, queries []ast.Body, node *pg_query.Node, diags *rego2sql.Diagnostics) error {
+	switch format {
+	case "text":
+		sql, err := rego2sql.Serialize(node)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		writeDiagnostics(stderr, diags)
+		_, err = fmt.Fprintln(stdout, sql)
+		return err
+	case "sql-params":
+		// The SQL, followed by the args as a JSON array.
+		sql, args, err := rego2sql.SerializeParams(node)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		if args == nil {
+			args = []any{}
+		}
+		encodedArgs, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("encode args: %w", err)
+		}
+		writeDiagnostics(stderr, diags)
+		_, err = fmt.Fprintf(stdout, "%s\n%s\n", sql, encodedArgs)
+		return err
+	case "json":
+		out := jsonOutput{
+			Queries:  make([]string, 0, len(queries)),
+			Args:     []any{},
+			Dropped:  []rego2sql.Dropped{},
+			Warnings: []string{},
+			Matches:  []rego2sql.Match{},
+		}
+		var err error
+		out.SQL, err = rego2sql.Serialize(node)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		var args []any
+		out.SQLParams, args, err = rego2sql.SerializeParams(node)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		out.Args = append(out.Args, args...)
+		for _, q := range queries {
+			out.Queries = append(out.Queries, q.String())
+		}
+		out.Dropped = append(out.Dropped, diags.Dropped...)
+		out.Warnings = append(out.Warnings, diags.Warnings...)
+		out.Matches = append(out.Matches, diags.Matches...)
+
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "ast":
+		data, err := protojson.MarshalOptions{Multiline: true}.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("encode ast: %w", err)
+		}
+		writeDiagnostics(stderr, diags)
+		_, err = fmt.Fprintln(stdout, string(data))
+		return err
+	}
+	return fmt.Errorf("unknown format %q, expected one of %v", format, formats)
+}
+
+func writeDiagnostics(w io.Writer, diags *rego2sql.Diagnostics) {
+	for _, d := range diags.Dropped {
+		_, _ = fmt.Fprintf(w, "dropped from query %d: %s: %s\n", d.Query, d.Rego, d.Reason)
+	}
+	for _, warning := range diags.Warnings {
+		_, _ = fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+}
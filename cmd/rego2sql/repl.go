@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+const replHelp = `Enter a rego query to partially evaluate and convert, such as
+'data.authz.allow = true' or 'input.object.owner = "u1"'.
+
+Commands:
+  :load <path>            Load a rego policy file or directory.
+  :mapping <file|preset>  Use a mapping file or preset.
+  :input                  Print the input.
+  :input <file>           Load the input from a JSON file.
+  :input <ref> <json>     Set a field of the input, ':input subject.id "u1"'.
+  :unknowns [refs]        Print or set the unknown refs, comma separated.
+  :dialect [name]         Print or set the SQL dialect, 'postgres' or
+                          'postgres-params' for the constants as $n params.
+  :history                Print the history.
+  :help                   Print this help.
+  :quit                   Exit.
+`
+
+// dialects are the SQL dialects that can be printed. 'postgres-params' is
+// postgres with the constants as $n parameters, see SerializeParams.
+var dialects = []string{"postgres", "postgres-params"}
+
+// repl is an interactive session for exploring how queries are converted.
+type repl struct {
+	out io.Writer
+
+	modules  map[string]string
+	input    map[string]any
+	unknowns []string
+	v0       bool
+	cfg      rego2sql.ConvertConfig
+	dialect  string
+
+	history     []string
+	historyFile string
+}
+
+func runREPL(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	var opts options
+	opts.register(fs)
+	historyFile := fs.String("history", defaultHistoryFile(), "File the history is saved to, empty to disable.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	partial, err := opts.partialConfig()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadMapping(opts.mapping)
+	if err != nil {
+		return fmt.Errorf("mapping: %w", err)
+	}
+
+	r := &repl{
+		out:         os.Stdout,
+		modules:     partial.Modules,
+		input:       map[string]any{},
+		unknowns:    opts.unknowns,
+		v0:          opts.v0,
+		cfg:         cfg,
+		dialect:     dialects[0],
+		historyFile: *historyFile,
+	}
+	if partial.Input != nil {
+		input, ok := partial.Input.(map[string]any)
+		if !ok {
+			return fmt.Errorf("input must be a JSON object")
+		}
+		r.input = input
+	}
+	r.loadHistory()
+
+	return r.run(os.Stdin)
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".rego2sql_history")
+}
+
+func (r *repl) run(in io.Reader) error {
+	fmt.Fprintln(r.out, "Type :help for help.")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, "rego2sql> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		r.addHistory(line)
+
+		if line == ":quit" || line == ":exit" {
+			return nil
+		}
+		if err := r.handle(line); err != nil {
+			fmt.Fprintf(r.out, "error: %s\n", err)
+		}
+	}
+}
+
+func (r *repl) handle(line string) error {
+	if !strings.HasPrefix(line, ":") {
+		return r.eval(line)
+	}
+
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":load":
+		if arg == "" {
+			return fmt.Errorf("usage: :load <path>")
+		}
+		modules, err := loadPolicies([]string{arg})
+		if err != nil {
+			return err
+		}
+		for name, module := range modules {
+			r.modules[name] = module
+			fmt.Fprintf(r.out, "loaded %s\n", name)
+		}
+	case ":mapping":
+		cfg, err := loadMapping(arg)
+		if err != nil {
+			return err
+		}
+		r.cfg = cfg
+	case ":input":
+		return r.setInput(arg)
+	case ":unknowns":
+		if arg != "" {
+			var unknowns listFlag
+			_ = unknowns.Set(arg)
+			r.unknowns = unknowns
+		}
+		fmt.Fprintf(r.out, "unknowns: %s\n", strings.Join(r.unknowns, ", "))
+	case ":dialect":
+		if arg != "" {
+			if !slices.Contains(dialects, arg) {
+				return fmt.Errorf("unsupported dialect %q, expected one of %s", arg, strings.Join(dialects, ", "))
+			}
+			r.dialect = arg
+		}
+		fmt.Fprintf(r.out, "dialect: %s\n", r.dialect)
+	case ":history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+	default:
+		return fmt.Errorf("unknown command %q, type :help for help", cmd)
+	}
+	return nil
+}
+
+// setInput prints the input, loads it from a file, or sets a single field.
+// Objects along the path of the field are created if they do not exist.
+func (r *repl) setInput(arg string) error {
+	if arg == "" {
+		data, err := json.MarshalIndent(r.input, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.out, string(data))
+		return nil
+	}
+
+	ref, raw, ok := strings.Cut(arg, " ")
+	if !ok {
+		input, err := loadInput(arg)
+		if err != nil {
+			return err
+		}
+		obj, ok := input.(map[string]any)
+		if !ok {
+			return fmt.Errorf("input must be a JSON object")
+		}
+		r.input = obj
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("decode value: %w", err)
+	}
+
+	path := strings.Split(strings.TrimPrefix(ref, "input."), ".")
+	obj := r.input
+	for i, key := range path[:len(path)-1] {
+		v, ok := obj[key]
+		if !ok {
+			next := map[string]any{}
+			obj[key] = next
+			obj = next
+			continue
+		}
+		next, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("input.%s is not an object", strings.Join(path[:i+1], "."))
+		}
+		obj = next
+	}
+	obj[path[len(path)-1]] = value
+	return nil
+}
+
+// eval partially evaluates the query, and prints every step of the
+// conversion.
+func (r *repl) eval(query string) error {
+	partial := rego2sql.PartialConfig{
+		Query:    query,
+		Modules:  r.modules,
+		Input:    r.input,
+		Unknowns: r.unknowns,
+	}
+	if r.v0 {
+		partial.RegoVersion = ast.RegoV0
+	}
+
+	queries, err := rego2sql.Partial(context.Background(), partial)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "Partial queries:")
+	if len(queries) == 0 {
+		fmt.Fprintln(r.out, "  (none)")
+	}
+	for i, q := range queries {
+		fmt.Fprintf(r.out, "  %d: %s\n", i, q)
+	}
+
+	node, diags, err := rego2sql.ConvertWithDiagnostics(r.cfg, queries)
+	if err != nil {
+		return fmt.Errorf("convert: %w", err)
+	}
+	if err := r.printSQL(node); err != nil {
+		return err
+	}
+
+	if len(diags.Matches) > 0 {
+		fmt.Fprintln(r.out, "Matches:")
+		for _, m := range diags.Matches {
+			fmt.Fprintf(r.out, "  %d: %s -> %s\n", m.Query, m.Ref, m.Matcher)
+		}
+	}
+	if len(diags.Dropped) > 0 {
+		fmt.Fprintln(r.out, "Dropped:")
+		for _, d := range diags.Dropped {
+			fmt.Fprintf(r.out, "  %d: %s: %s\n", d.Query, d.Rego, d.Reason)
+		}
+	}
+	for _, w := range diags.Warnings {
+		fmt.Fprintf(r.out, "warning: %s\n", w)
+	}
+	return nil
+}
+
+// printSQL prints the SQL in the dialect of the session.
+func (r *repl) printSQL(node *pg_query.Node) error {
+	if r.dialect != "postgres-params" {
+		sql, err := rego2sql.Serialize(node)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		fmt.Fprintf(r.out, "SQL:\n  %s\n", sql)
+		return nil
+	}
+
+	sql, args, err := rego2sql.SerializeParams(node)
+	if err != nil {
+		return fmt.Errorf("serialize: %w", err)
+	}
+	if args == nil {
+		args = []any{}
+	}
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("encode args: %w", err)
+	}
+	fmt.Fprintf(r.out, "SQL:\n  %s\nArgs:\n  %s\n", sql, encodedArgs)
+	return nil
+}
+
+func (r *repl) loadHistory() {
+	if r.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(r.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+}
+
+// addHistory records the line, and appends it to the history file. Failing
+// to save the history is not fatal.
+func (r *repl) addHistory(line string) {
+	r.history = append(r.history, line)
+	if r.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = fmt.Fprintln(f, line)
+}
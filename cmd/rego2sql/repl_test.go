@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestREPL(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.rego")
+	require.NoError(t, os.WriteFile(policy, []byte("package authz\n\nallow if input.object.owner = input.subject.id\n"), 0o600))
+	historyFile := filepath.Join(dir, "history")
+
+	var out strings.Builder
+	r := &repl{
+		out:         &out,
+		modules:     map[string]string{},
+		input:       map[string]any{},
+		dialect:     dialects[0],
+		historyFile: historyFile,
+	}
+	script := strings.Join([]string{
+		":load " + policy,
+		":mapping coder.workspace",
+		":unknowns input.object",
+		`:input subject.id "u1"`,
+		`:input subject.id.name "u1"`,
+		":input",
+		"data.authz.allow = true",
+		":dialect postgres-params",
+		"data.authz.allow = true",
+		":dialect mysql",
+		":dialect",
+		":unknown",
+		":quit",
+		"not run",
+	}, "\n")
+	require.NoError(t, r.run(strings.NewReader(script)))
+
+	got := out.String()
+	for _, expected := range []string{
+		"loaded " + policy,
+		"unknowns: input.object",
+		"error: input.subject.id is not an object",
+		`"id": "u1"`,
+		"Partial queries:\n  0: \"u1\" = input.object.owner\n",
+		"SQL:\n  ('u1' = owner_id::text)\n",
+		"SQL:\n  ($1 = owner_id::text)\nArgs:\n  [\"u1\"]\n",
+		`error: unsupported dialect "mysql", expected one of postgres, postgres-params`,
+		"dialect: postgres-params\n",
+		"0: input.object.owner -> ",
+		`error: unknown command ":unknown"`,
+	} {
+		require.Contains(t, got, expected)
+	}
+	require.NotContains(t, got, "not run")
+
+	// The history is saved, and loaded by the next session.
+	next := &repl{out: &out, historyFile: historyFile}
+	next.loadHistory()
+	require.Len(t, next.history, 13)
+	require.Equal(t, ":quit", next.history[12])
+}
+
+func TestREPLSetInput(t *testing.T) {
+	t.Parallel()
+
+	r := &repl{out: &strings.Builder{}, input: map[string]any{}}
+	require.NoError(t, r.setInput(`input.subject.id "u1"`))
+	require.NoError(t, r.setInput(`subject.groups ["g1"]`))
+	require.NoError(t, r.setInput(`action "read"`))
+	require.Equal(t, map[string]any{
+		"subject": map[string]any{"id": "u1", "groups": []any{"g1"}},
+		"action":  "read",
+	}, r.input)
+
+	require.EqualError(t, r.setInput(`subject.id.name "u1"`), "input.subject.id is not an object")
+	require.EqualError(t, r.setInput(`action.name.first "u1"`), "input.action is not an object")
+	require.Error(t, r.setInput(`subject.id {`))
+	require.Error(t, r.setInput(filepath.Join(t.TempDir(), "missing.json")))
+}
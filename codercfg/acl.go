@@ -1,9 +1,10 @@
 package codercfg
 
 import (
+	"strings"
+
 	"github.com/Emyrk/rego2sql"
 	"github.com/open-policy-agent/opa/v1/ast"
-	pg_query "github.com/pganalyze/pg_query_go/v6"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -41,44 +42,42 @@ func (g ACLMatcher) ConvertVariable(rego ast.Ref) (*rego2sql.Item, bool) {
 		return nil, false
 	}
 
-	// We expect 1 more term. Either a ref or a string.
-	if len(left) != 1 {
+	// We expect 1 more term. Either a ref, a string, or a variable to
+	// iterate over all groups. Another variable can iterate over the
+	// actions.
+	switch len(left) {
+	case 1:
+	case 2:
+		if _, ok := left[1].Value.(ast.Var); !ok {
+			return nil, false
+		}
+	default:
 		return nil, false
 	}
 
-	fields := make([]*pg_query.Node, 0, len(g.ColumnRef))
-	for _, p := range g.ColumnRef {
-		fields = append(fields, pg_query.MakeStrNode(p))
-	}
-
-	l := pg_query.MakeColumnRefNode(fields, 0)
-	var r *rego2sql.Item
-
-	// If the remaining is a variable, then we need to convert it.
-	// Assuming we support variable fields.
-	ref, ok := left[0].Value.(ast.Ref)
-	if ok && g.FieldReference != nil {
-		lastRefNode, ok := g.FieldReference.ConvertVariable(ref)
-		if !ok {
+	switch left[0].Value.(type) {
+	case ast.String, ast.Var:
+	case ast.Ref:
+		// Assuming we support variable fields.
+		if g.FieldReference == nil {
 			return nil, false
 		}
-		r = lastRefNode
+	default:
+		return nil, false
 	}
 
-	if r == nil {
-		// If it is a string, we assume it is a literal
-		groupName, ok := left[0].Value.(ast.String)
-		if ok {
-			r = &rego2sql.Item{
-				Node:  pg_query.MakeAConstStrNode(string(groupName), 0),
-				Value: cty.StringVal(string(groupName)),
-			}
-		}
-	}
+	// The json object is a map of lists, which the JSONB matcher knows how to
+	// handle:
+	//	(group_acl -> 'all_users') ? 'read'
+	//	EXISTS (SELECT 1 FROM jsonb_each(group_acl) group_acl_entry(key, value) WHERE group_acl_entry.value ? 'read')
+	return rego2sql.NewJSONBPathMatcher(g.FieldReference, g.RegoPath, g.ColumnRef, cty.Map(cty.List(cty.String))).
+		ConvertVariable(rego)
+}
+
+func (g ACLMatcher) MatchPath() []string {
+	return g.RegoPath
+}
 
-	return &rego2sql.Item{
-		Node:   pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP, []*pg_query.Node{pg_query.MakeStrNode("->")}, l, r.Node, 0),
-		Value:  rego2sql.MarkJSONB(cty.ListValEmpty(cty.String)), // really a uuid, and really json...
-		Source: rego.String(),
-	}, true
+func (g ACLMatcher) String() string {
+	return "acl column " + strings.Join(g.ColumnRef, ".")
 }
@@ -1,6 +1,114 @@
 package codercfg
 
-import "github.com/Emyrk/rego2sql"
+import (
+	"github.com/Emyrk/rego2sql"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// The converters below map the 'input.object' of Coder's RBAC rego policy to
+// the columns of each resource table. The object has the following shape:
+//
+//	{
+//	  "id": "<uuid>",
+//	  "owner": "<user uuid>",
+//	  "org_owner": "<organization uuid>",
+//	  "any_org": false,
+//	  "type": "<resource type>",
+//	  "acl_user_list": {"<user uuid>": ["<actions>"]},
+//	  "acl_group_list": {"<group uuid>": ["<actions>"]}
+//	}
+//
+// UUID columns are cast to text, as the rego values are always strings.
+
+// WorkspaceConverter is for the 'workspaces' table.
+func WorkspaceConverter() *rego2sql.VariableConverter {
+	matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+		resourceIDMatcher("id"),
+		organizationOwnerMatcher("organization_id"),
+		userOwnerMatcher("owner_id"),
+		anyOrgMatcher(),
+		resourceTypeMatcher("workspace"),
+	)
+	matcher.RegisterMatcher(
+		GroupACLMatcher(matcher),
+		UserACLMatcher(matcher),
+	)
+	return matcher
+}
+
+// TemplateConverter is for the 'templates' table.
+func TemplateConverter() *rego2sql.VariableConverter {
+	matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+		resourceIDMatcher("id"),
+		organizationOwnerMatcher("organization_id"),
+		// Templates are only owned by an organization, Coder evaluates them
+		// with no owner.
+		rego2sql.ConstVarMatcher([]string{"input", "object", "owner"}, cty.StringVal("")),
+		anyOrgMatcher(),
+		resourceTypeMatcher("template"),
+	)
+	matcher.RegisterMatcher(
+		GroupACLMatcher(matcher),
+		UserACLMatcher(matcher),
+	)
+	return matcher
+}
+
+// UserConverter is for the 'users' table.
+func UserConverter() *rego2sql.VariableConverter {
+	matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+		resourceIDMatcher("id"),
+		// Users are never owned by an organization.
+		rego2sql.ConstVarMatcher([]string{"input", "object", "org_owner"}, cty.StringVal("")),
+		// Users are always owned by themselves.
+		userOwnerMatcher("id"),
+		anyOrgMatcher(),
+		resourceTypeMatcher("user"),
+	)
+	matcher.RegisterMatcher(
+		// Users have no ACLs.
+		rego2sql.AlwaysFalseMatcher(GroupACLMatcher(matcher)),
+		rego2sql.AlwaysFalseMatcher(UserACLMatcher(matcher)),
+	)
+	return matcher
+}
+
+// GroupConverter is for the 'groups' table.
+func GroupConverter() *rego2sql.VariableConverter {
+	matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+		resourceIDMatcher("id"),
+		organizationOwnerMatcher("organization_id"),
+		// Groups are only owned by an organization, Coder evaluates them
+		// with no owner.
+		rego2sql.ConstVarMatcher([]string{"input", "object", "owner"}, cty.StringVal("")),
+		anyOrgMatcher(),
+		resourceTypeMatcher("group"),
+	)
+	matcher.RegisterMatcher(
+		// Groups have no ACLs.
+		rego2sql.AlwaysFalseMatcher(GroupACLMatcher(matcher)),
+		rego2sql.AlwaysFalseMatcher(UserACLMatcher(matcher)),
+	)
+	return matcher
+}
+
+// OrganizationMemberConverter is for the 'organization_members' table. A
+// membership is identified by, and owned by, its user.
+func OrganizationMemberConverter() *rego2sql.VariableConverter {
+	matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+		resourceIDMatcher("user_id"),
+		organizationOwnerMatcher("organization_id"),
+		userOwnerMatcher("user_id"),
+		anyOrgMatcher(),
+		resourceTypeMatcher("organization_member"),
+	)
+	matcher.RegisterMatcher(
+		// Organization members have no ACLs.
+		rego2sql.AlwaysFalseMatcher(GroupACLMatcher(matcher)),
+		rego2sql.AlwaysFalseMatcher(UserACLMatcher(matcher)),
+	)
+	return matcher
+}
 
 func GroupACLMatcher(m rego2sql.VariableMatcher) rego2sql.VariableMatcher {
 	return ACLGroupMatcher(m, []string{"input", "object", "acl_group_list"}, []string{"group_acl"})
@@ -9,3 +117,33 @@ func GroupACLMatcher(m rego2sql.VariableMatcher) rego2sql.VariableMatcher {
 func UserACLMatcher(m rego2sql.VariableMatcher) rego2sql.VariableMatcher {
 	return ACLGroupMatcher(m, []string{"input", "object", "acl_user_list"}, []string{"user_acl"})
 }
+
+func resourceIDMatcher(column string) rego2sql.VariableMatcher {
+	return uuidMatcher([]string{"input", "object", "id"}, column)
+}
+
+func organizationOwnerMatcher(column string) rego2sql.VariableMatcher {
+	return uuidMatcher([]string{"input", "object", "org_owner"}, column)
+}
+
+func userOwnerMatcher(column string) rego2sql.VariableMatcher {
+	return uuidMatcher([]string{"input", "object", "owner"}, column)
+}
+
+// anyOrgMatcher is always false, as every row belongs to a single
+// organization.
+func anyOrgMatcher() rego2sql.VariableMatcher {
+	return rego2sql.ConstVarMatcher([]string{"input", "object", "any_org"}, cty.False)
+}
+
+// resourceTypeMatcher is constant, as each table holds a single type.
+func resourceTypeMatcher(resourceType string) rego2sql.VariableMatcher {
+	return rego2sql.ConstVarMatcher([]string{"input", "object", "type"}, cty.StringVal(resourceType))
+}
+
+func uuidMatcher(regoPath []string, column string) rego2sql.VariableMatcher {
+	return rego2sql.CastVarMatcher(
+		rego2sql.StringVarMatcher(regoPath, []string{column}, cty.UnknownVal(cty.String)),
+		"text",
+	)
+}
@@ -0,0 +1,189 @@
+package codercfg_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConverters converts hand-written queries with 'input.object' as the
+// unknown, for shapes TestCoderPolicy does not cover, such as any_org and ACL
+// wildcards.
+func TestConverters(t *testing.T) {
+	t.Parallel()
+
+	var (
+		// Site wide permission
+		site = []string{``}
+		// Organization member permission
+		orgMember = []string{
+			`input.object.org_owner != ""; input.object.org_owner in {"org1", "org2"}`,
+		}
+		// User permission, within any of the user's organizations
+		owner = []string{
+			`input.object.org_owner in {"org1"}; input.object.owner != ""; "user1" = input.object.owner`,
+			`input.object.any_org = true; input.object.owner != ""; "user1" = input.object.owner`,
+		}
+		// ACL permissions
+		acls = []string{
+			`"read" in input.object.acl_user_list["user1"]`,
+			`"*" in input.object.acl_user_list.user1`,
+			`"read" in input.object.acl_group_list["group1"]`,
+			`"read" in input.object.acl_group_list[input.object.org_owner]`,
+		}
+		// ACL checks on any group, or any action
+		aclWildcard = []string{
+			`"read" in input.object.acl_group_list[_]`,
+			`input.object.acl_user_list["user1"][_] = "read"`,
+			`count(input.object.acl_user_list["user1"]) > 0`,
+		}
+		resourceType = []string{
+			`input.object.type = "workspace"; input.object.owner = "user1"`,
+		}
+	)
+
+	testCases := []struct {
+		Name      string
+		Converter *rego2sql.VariableConverter
+		Queries   []string
+		Expected  string
+	}{
+		{
+			Name:      "WorkspaceSite",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   site,
+			Expected:  "true",
+		},
+		{
+			Name:      "WorkspaceOrgMember",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   orgMember,
+			Expected:  "(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']))",
+		},
+		{
+			Name:      "WorkspaceOwner",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   owner,
+			Expected: "(organization_id::text = ANY(ARRAY['org1']) AND owner_id::text <> '' AND 'user1' = owner_id::text) OR " +
+				"(false = true AND owner_id::text <> '' AND 'user1' = owner_id::text)",
+		},
+		{
+			Name:      "WorkspaceACL",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   acls,
+			Expected: "((user_acl -> 'user1') ?| ARRAY['read', '*']) OR " +
+				"((group_acl -> 'group1') ? 'read') OR " +
+				"((group_acl -> organization_id::text) ? 'read')",
+		},
+		{
+			Name:      "WorkspaceACLWildcard",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   aclWildcard,
+			Expected: "(EXISTS (SELECT 1 FROM jsonb_each(group_acl) group_acl_entry(key, value) WHERE group_acl_entry.value ? 'read')) OR " +
+				"(EXISTS (SELECT 1 FROM jsonb_array_elements_text(user_acl -> 'user1') user_acl_entry(value) WHERE user_acl_entry.value = 'read')) OR " +
+				"(jsonb_array_length(user_acl -> 'user1') > 0)",
+		},
+		{
+			Name:      "WorkspaceType",
+			Converter: codercfg.WorkspaceConverter(),
+			Queries:   resourceType,
+			Expected:  "('workspace' = 'workspace' AND owner_id::text = 'user1')",
+		},
+		{
+			Name:      "TemplateOrgMember",
+			Converter: codercfg.TemplateConverter(),
+			Queries:   orgMember,
+			Expected:  "(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']))",
+		},
+		{
+			Name:      "TemplateOwner",
+			Converter: codercfg.TemplateConverter(),
+			Queries:   owner,
+			// Templates and groups are evaluated with no owner.
+			Expected: "(organization_id::text = ANY(ARRAY['org1']) AND '' <> '' AND 'user1' = '') OR " +
+				"(false = true AND '' <> '' AND 'user1' = '')",
+		},
+		{
+			Name:      "TemplateACL",
+			Converter: codercfg.TemplateConverter(),
+			Queries:   acls,
+			Expected: "((user_acl -> 'user1') ?| ARRAY['read', '*']) OR " +
+				"((group_acl -> 'group1') ? 'read') OR " +
+				"((group_acl -> organization_id::text) ? 'read')",
+		},
+		{
+			Name:      "UserOrgMember",
+			Converter: codercfg.UserConverter(),
+			Queries:   orgMember,
+			Expected:  "('' <> '' AND '' = ANY(ARRAY['org1', 'org2']))",
+		},
+		{
+			Name:      "UserOwner",
+			Converter: codercfg.UserConverter(),
+			Queries:   owner,
+			Expected: "('' = ANY(ARRAY['org1']) AND id::text <> '' AND 'user1' = id::text) OR " +
+				"(false = true AND id::text <> '' AND 'user1' = id::text)",
+		},
+		{
+			Name:      "UserACL",
+			Converter: codercfg.UserConverter(),
+			Queries:   acls,
+			Expected:  "false",
+		},
+		{
+			Name:      "UserType",
+			Converter: codercfg.UserConverter(),
+			Queries:   resourceType,
+			Expected:  "('user' = 'workspace' AND id::text = 'user1')",
+		},
+		{
+			Name:      "GroupOrgMember",
+			Converter: codercfg.GroupConverter(),
+			Queries:   append(orgMember, acls...),
+			Expected:  "(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']))",
+		},
+		{
+			Name:      "GroupOwner",
+			Converter: codercfg.GroupConverter(),
+			Queries:   owner,
+			// Templates and groups are evaluated with no owner.
+			Expected: "(organization_id::text = ANY(ARRAY['org1']) AND '' <> '' AND 'user1' = '') OR " +
+				"(false = true AND '' <> '' AND 'user1' = '')",
+		},
+		{
+			Name:      "OrganizationMemberOrgMember",
+			Converter: codercfg.OrganizationMemberConverter(),
+			Queries:   orgMember,
+			Expected:  "(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']))",
+		},
+		{
+			Name:      "OrganizationMemberOwner",
+			Converter: codercfg.OrganizationMemberConverter(),
+			Queries:   append(owner, acls...),
+			Expected: "(organization_id::text = ANY(ARRAY['org1']) AND user_id::text <> '' AND 'user1' = user_id::text) OR " +
+				"(false = true AND user_id::text <> '' AND 'user1' = user_id::text)",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			queries := make([]ast.Body, 0, len(tc.Queries))
+			for _, q := range tc.Queries {
+				queries = append(queries, ast.MustParseBodyWithOpts(q, ast.ParserOptions{AllFutureKeywords: true}))
+			}
+
+			node, err := rego2sql.Convert(rego2sql.ConvertConfig{VariableConverter: tc.Converter}, queries)
+			require.NoError(t, err, "convert")
+
+			sql, err := rego2sql.Serialize(node)
+			require.NoError(t, err, "serialize")
+			require.Equal(t, tc.Expected, sql)
+		})
+	}
+}
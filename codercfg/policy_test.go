@@ -0,0 +1,194 @@
+package codercfg_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCoderPolicy partially evaluates Coder's RBAC policy for its built-in
+// roles, and converts the queries it produces. The unknowns are the ones
+// Coder leaves unknown when filtering.
+func TestCoderPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := os.ReadFile("testdata/policy.rego")
+	require.NoError(t, err)
+
+	perm := func(resourceType, action string) map[string]any {
+		return map[string]any{"negate": false, "resource_type": resourceType, "action": action}
+	}
+	role := func(name string, site []any, org map[string]any, user []any) map[string]any {
+		return map[string]any{"name": name, "site": site, "org": org, "user": user}
+	}
+	var (
+		owner     = role("owner", []any{perm("*", "*")}, map[string]any{}, []any{})
+		member    = role("member", []any{}, map[string]any{}, []any{perm("*", "*")})
+		orgMember = role("organization-member:org1", []any{}, map[string]any{
+			"org1": []any{perm("template", "read")},
+		}, []any{})
+		orgAdmin = role("organization-admin:org1", []any{}, map[string]any{
+			"org1": []any{perm("*", "*")},
+		}, []any{})
+		// A member of org2 with no permissions in it.
+		org2Member = role("organization-member:org2", []any{}, map[string]any{"org2": []any{}}, []any{})
+	)
+
+	testCases := []struct {
+		Name      string
+		Converter *rego2sql.VariableConverter
+		Type      string
+		Roles     []any
+		// AllowList of the scope, defaults to all resources.
+		AllowList []any
+		Expected  []string
+	}{
+		{
+			Name:      "WorkspaceOwner",
+			Converter: codercfg.WorkspaceConverter(),
+			Type:      "workspace",
+			Roles:     []any{owner},
+			Expected:  []string{"true"},
+		},
+		{
+			Name:      "WorkspaceMember",
+			Converter: codercfg.WorkspaceConverter(),
+			Type:      "workspace",
+			Roles:     []any{member, orgMember, org2Member},
+			Expected: []string{
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']) AND owner_id::text <> '' AND 'user1' = owner_id::text)",
+				"(organization_id::text = '' AND owner_id::text <> '' AND 'user1' = owner_id::text)",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']) AND (group_acl -> 'group1') ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']) AND (group_acl -> 'group1') ? '*')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']) AND (group_acl -> organization_id::text) ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1', 'org2']) AND (group_acl -> organization_id::text) ? '*')",
+				"((user_acl -> 'user1') ?| ARRAY['read', '*'])",
+			},
+		},
+		{
+			Name:      "WorkspaceOrgAdmin",
+			Converter: codercfg.WorkspaceConverter(),
+			Type:      "workspace",
+			Roles:     []any{member, orgAdmin},
+			Expected: []string{
+				"('org1' = organization_id::text)",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND owner_id::text <> '' AND 'user1' = owner_id::text)",
+				"(organization_id::text = '' AND owner_id::text <> '' AND 'user1' = owner_id::text)",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> 'group1') ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> 'group1') ? '*')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> organization_id::text) ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> organization_id::text) ? '*')",
+				"((user_acl -> 'user1') ?| ARRAY['read', '*'])",
+			},
+		},
+		{
+			Name:      "WorkspaceScoped",
+			Converter: codercfg.WorkspaceConverter(),
+			Type:      "workspace",
+			Roles:     []any{owner},
+			AllowList: []any{"ws1", "ws2"},
+			Expected: []string{
+				"(id::text = ANY(ARRAY['ws1', 'ws2']))",
+				"((user_acl -> 'user1') ? 'read' AND id::text = ANY(ARRAY['ws1', 'ws2']))",
+				"((user_acl -> 'user1') ? '*' AND id::text = ANY(ARRAY['ws1', 'ws2']))",
+			},
+		},
+		{
+			// Templates are evaluated with no owner, so the queries of the
+			// user owner compare '' to the user and match no rows.
+			Name:      "TemplateMember",
+			Converter: codercfg.TemplateConverter(),
+			Type:      "template",
+			Roles:     []any{member, orgMember},
+			Expected: []string{
+				"('org1' = organization_id::text)",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND '' <> '' AND 'user1' = '')",
+				"(organization_id::text = '' AND '' <> '' AND 'user1' = '')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> 'group1') ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> 'group1') ? '*')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> organization_id::text) ? 'read')",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND (group_acl -> organization_id::text) ? '*')",
+				"((user_acl -> 'user1') ?| ARRAY['read', '*'])",
+			},
+		},
+		{
+			Name:      "TemplateNoRoles",
+			Converter: codercfg.TemplateConverter(),
+			Type:      "template",
+			Roles:     []any{},
+			Expected:  []string{"((user_acl -> 'user1') ?| ARRAY['read', '*'])"},
+		},
+		{
+			// Users are owned by themselves, and have no organization or
+			// ACLs.
+			Name:      "UserMember",
+			Converter: codercfg.UserConverter(),
+			Type:      "user",
+			Roles:     []any{member, orgMember},
+			Expected: []string{
+				"('' <> '' AND '' = ANY(ARRAY['org1']) AND id::text <> '' AND 'user1' = id::text)",
+				"('' = '' AND id::text <> '' AND 'user1' = id::text)",
+			},
+		},
+		{
+			Name:      "GroupOrgAdmin",
+			Converter: codercfg.GroupConverter(),
+			Type:      "group",
+			Roles:     []any{member, orgAdmin},
+			Expected: []string{
+				"('org1' = organization_id::text)",
+				"(organization_id::text <> '' AND organization_id::text = ANY(ARRAY['org1']) AND '' <> '' AND 'user1' = '')",
+				"(organization_id::text = '' AND '' <> '' AND 'user1' = '')",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			allowList := tc.AllowList
+			if allowList == nil {
+				allowList = []any{"*"}
+			}
+			scope := role("all", []any{perm("*", "*")}, map[string]any{}, []any{})
+			scope["allow_list"] = allowList
+
+			queries, err := rego2sql.Partial(context.Background(), rego2sql.PartialConfig{
+				Query:   "data.authz.allow = true",
+				Modules: map[string]string{"policy.rego": string(policy)},
+				Input: map[string]any{
+					"subject": map[string]any{
+						"id":     "user1",
+						"roles":  tc.Roles,
+						"groups": []any{"group1"},
+						"scope":  scope,
+					},
+					"object": map[string]any{"type": tc.Type},
+					"action": "read",
+				},
+				Unknowns: []string{
+					"input.object.id",
+					"input.object.owner",
+					"input.object.org_owner",
+					"input.object.acl_user_list",
+					"input.object.acl_group_list",
+				},
+			})
+			require.NoError(t, err, "partial")
+
+			node, err := rego2sql.Convert(rego2sql.ConvertConfig{VariableConverter: tc.Converter}, queries)
+			require.NoError(t, err, "convert")
+
+			sql, err := rego2sql.Serialize(node)
+			require.NoError(t, err, "serialize")
+			require.Equal(t, strings.Join(tc.Expected, " OR "), sql)
+		})
+	}
+}
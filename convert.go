@@ -11,39 +11,116 @@ type ConvertConfig struct {
 	// VariableConverter is called each time a var is encountered. This creates
 	// the SQL ast for the variable.
 	VariableConverter VariableMatcher
+	// UnknownVarsFalse treats any query containing a variable that cannot be
+	// converted as 'false', instead of returning an error.
+	UnknownVarsFalse bool
+
+	// TableAlias qualifies every unqualified column reference, so the
+	// columns are not ambiguous in queries with joins. It is the name of the
+	// table, or its alias in the query. Schema optionally qualifies the table
+	// as well, and can only be used with a table name.
+	//	TableAlias: "w", organization_id -> w.organization_id
+	TableAlias string
+	Schema     string
+	// TableAliases renames the tables of qualified column references, so the
+	// same matchers can be reused in queries with different aliases.
+	//	TableAliases: {"templates": "t"}, templates.name -> t.name
+	TableAliases map[string]string
+
+	// Validate checks the converted SQL against the schema, see Schema.
+	// ValidateTable is the table of the unqualified columns, and defaults to
+	// TableAlias.
+	Validate      *Schema
+	ValidateTable string
 }
 
 func Convert(cfg ConvertConfig, queries []ast.Body) (*pg_query.Node, error) {
+	return convert(cfg, queries, &Diagnostics{}, nil)
+}
+
+// convert converts the queries, collecting the diagnostics. If explain is not
+// nil, the explanation of each query is added to it.
+func convert(cfg ConvertConfig, queries []ast.Body, diags *Diagnostics, explain *Explanation) (*pg_query.Node, error) {
 	// the rego policy is false if no queries exist to satisfy
 	if len(queries) == 0 {
+		diags.warn("there are no queries, the filter matches no rows")
 		return constBoolean(false, 0), nil
 	}
 
 	// All partial queries are OR'd together
 	// If any of them have a length of 0, then that query is 'true'.
 	// Which means the policy is 'true'.
-	for _, q := range queries {
+	for i, q := range queries {
 		if len(q) == 0 {
+			diags.warn("query %d is always true, the filter matches every row", i)
+			if explain != nil {
+				explain.Queries = append(explain.Queries, QueryExplanation{
+					Query: i,
+					SQL:   "true",
+					Note:  "the query is empty, so the filter matches every row",
+					Exprs: []ExprExplanation{},
+				})
+			}
 			return constBoolean(true, 0), nil
 		}
 	}
 
-	crv := &converter{
-		stack: newStack[*Item](),
-	}
-
 	// A list of all the nodes that will be OR'd together
 	nodes := make([]*pg_query.Node, 0, len(queries))
-	for _, q := range queries {
-		qn, err := crv.convertQuery(cfg, q)
+	// explained is the index of the explanation of each node.
+	explained := make([]int, 0, len(queries))
+	for i, q := range queries {
+		crv := &converter{
+			cfg:   cfg,
+			stack: newStack[*Item](),
+			diags: diags,
+			query: i,
+		}
+		if explain != nil {
+			crv.explain = &QueryExplanation{Query: i, Rego: q.String(), Exprs: []ExprExplanation{}}
+		}
+
+		qn, err := crv.convertQuery(q)
 		if err != nil {
+			if crv.isFalse(err) {
+				// A false query can never satisfy the policy, so it is
+				// dropped from the OR.
+				diags.drop(i, q.String(), "the query is always false: %s", err)
+				if explain != nil {
+					crv.explain.Note = fmt.Sprintf("the query is always false: %s", err)
+					explain.Queries = append(explain.Queries, *crv.explain)
+				}
+				continue
+			}
 			return nil, fmt.Errorf("convert query: %w", err)
 		}
 		nodes = append(nodes, qn)
+		if explain != nil {
+			explained = append(explained, len(explain.Queries))
+			explain.Queries = append(explain.Queries, *crv.explain)
+		}
 	}
 
-	orJoined := pg_query.MakeBoolExprNode(pg_query.BoolExprType_OR_EXPR, nodes, 0)
-	return orJoined, nil
+	if len(nodes) == 0 {
+		diags.warn("every query is always false, the filter matches no rows")
+		return constBoolean(false, 0), nil
+	}
+
+	merged, into := mergeKeyExists(nodes)
+	if explain != nil {
+		if err := explainMerged(cfg, explain, merged, into, explained); err != nil {
+			return nil, err
+		}
+	}
+
+	orJoined := pg_query.MakeBoolExprNode(pg_query.BoolExprType_OR_EXPR, merged, 0)
+	qualified := qualifyColumns(cfg, orJoined)
+	if cfg.Validate != nil {
+		if err := cfg.Validate.validate(cfg, qualified); err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+	}
+	return qualified, nil
 }
 
 func constBoolean(val bool, location int32) *pg_query.Node {
@@ -77,3 +154,44 @@ func constFloat(float string, location int32) *pg_query.Node {
 		},
 	}
 }
+
+func typeCast(n *pg_query.Node, typeName ...string) *pg_query.Node {
+	names := make([]*pg_query.Node, 0, len(typeName))
+	for _, name := range typeName {
+		names = append(names, pg_query.MakeStrNode(name))
+	}
+
+	return &pg_query.Node{
+		Node: &pg_query.Node_TypeCast{
+			TypeCast: &pg_query.TypeCast{
+				Arg: n,
+				TypeName: &pg_query.TypeName{
+					Names:   names,
+					Typemod: -1,
+				},
+			},
+		},
+	}
+}
+
+// arrayTypeCast casts the node to an array of the given type.
+func arrayTypeCast(n *pg_query.Node, typeName ...string) *pg_query.Node {
+	cast := typeCast(n, typeName...)
+	cast.GetTypeCast().TypeName.ArrayBounds = []*pg_query.Node{pg_query.MakeIntNode(-1)}
+	return cast
+}
+
+func funcCall(name string, args ...*pg_query.Node) *pg_query.Node {
+	return pg_query.MakeFuncCallNode([]*pg_query.Node{pg_query.MakeStrNode(name)}, args, 0)
+}
+
+func arrayNode(elems []*pg_query.Node) *pg_query.Node {
+	return &pg_query.Node{
+		Node: &pg_query.Node_AArrayExpr{
+			AArrayExpr: &pg_query.A_ArrayExpr{
+				Elements: elems,
+				Location: 0,
+			},
+		},
+	}
+}
@@ -53,6 +53,65 @@ func TestRegoQueries(t *testing.T) {
 		return matcher
 	}
 
+	netConverts := func() *rego2sql.VariableConverter {
+		return rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "ip"}, []string{"ip"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "cidr"}, []string{"cidr"}, cty.UnknownVal(cty.String)),
+		)
+	}
+
+	jsonbConverts := func() *rego2sql.VariableConverter {
+		matcher := rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+		)
+		matcher.RegisterMatcher(
+			rego2sql.NewJSONBPathMatcher(matcher, []string{"input", "object", "metadata"}, []string{"metadata"}, cty.Object(map[string]cty.Type{
+				"labels":   cty.Map(cty.String),
+				"replicas": cty.Number,
+				"tags":     cty.List(cty.String),
+				"extra":    cty.DynamicPseudoType,
+			})),
+			rego2sql.NewJSONBPathMatcher(matcher, []string{"input", "object", "settings"}, []string{"settings"}, cty.Map(cty.Object(map[string]cty.Type{
+				"enabled": cty.Bool,
+			}))),
+		)
+		return matcher
+	}
+
+	relatedConverts := func() *rego2sql.VariableConverter {
+		members := &rego2sql.RelatedTableMatcher{
+			RegoPath:    []string{"input", "object", "members"},
+			Table:       "workspace_members",
+			Alias:       "m",
+			ForeignKey:  "workspace_id",
+			ParentKey:   []string{"workspaces", "id"},
+			ValueColumn: "user_id",
+			ValueType:   cty.String,
+			Fields: map[string]rego2sql.RelatedColumn{
+				"user_id": {Column: "user_id", Type: cty.String},
+				"role":    {Column: "role", Type: cty.String},
+			},
+		}
+		return rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+			members,
+		)
+	}
+
+	sessionConverts := func() *rego2sql.VariableConverter {
+		return rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "org_owner"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "level"}, []string{"level"}, cty.UnknownVal(cty.Number)),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "id"}, "app.user_id", cty.String),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "orgs"}, "app.org_ids", cty.List(cty.String)),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "level"}, "app.level", cty.Number),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "levels"}, "app.levels", cty.List(cty.Number)),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "admin"}, "app.admin", cty.Bool),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "roles"}, "app.roles", cty.Map(cty.String)),
+		)
+	}
+
 	testCases := []struct {
 		Name                 string
 		Queries              []string
@@ -62,6 +121,9 @@ func TestRegoQueries(t *testing.T) {
 
 		VariableConverter rego2sql.VariableMatcher
 		UnknownVarsFalse  bool
+		TableAlias        string
+		Schema            string
+		TableAliases      map[string]string
 	}{
 		{
 			Name:        "Empty",
@@ -76,7 +138,7 @@ func TestRegoQueries(t *testing.T) {
 		{
 			Name:        "False",
 			Queries:     []string{`false`},
-			ExpectedSQL: "(false)",
+			ExpectedSQL: "false",
 		},
 		{
 			Name:        "BoolOps",
@@ -86,7 +148,7 @@ func TestRegoQueries(t *testing.T) {
 		{
 			Name:        "MultipleBool",
 			Queries:     []string{"true", "false"},
-			ExpectedSQL: "(true) OR (false)",
+			ExpectedSQL: "(true)",
 		},
 		{
 			Name: "Numbers",
@@ -171,6 +233,556 @@ func TestRegoQueries(t *testing.T) {
 			},
 			ExpectedSQL:       "false",
 			VariableConverter: noACLs(),
+			UnknownVarsFalse:  true,
+		},
+		{
+			Name: "NoACLsError",
+			Queries: []string{
+				`"read" in input.object.acl_group_list[input.object.org_owner]`,
+			},
+			ExpectError:       true,
+			VariableConverter: noACLs(),
+		},
+		{
+			Name: "NoACLsOtherQuery",
+			Queries: []string{
+				`"read" in input.object.acl_group_list[input.object.org_owner]`,
+				`input.object.owner = "me"`,
+			},
+			ExpectedSQL:       "(owner = 'me')",
+			VariableConverter: noACLs(),
+			UnknownVarsFalse:  true,
+		},
+		// JSONB paths
+		{
+			Name: "JSONBNestedString",
+			Queries: []string{
+				`input.object.metadata.labels.env = "prod"`,
+			},
+			ExpectedSQL:       "((metadata #>> ARRAY['labels', 'env']) = 'prod')",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBNumber",
+			Queries: []string{
+				`input.object.metadata.replicas > 3`,
+			},
+			ExpectedSQL:       "(CAST(metadata ->> 'replicas' AS numeric) > 3)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBIndex",
+			Queries: []string{
+				`input.object.metadata.tags[0] == "a"`,
+			},
+			ExpectedSQL:       "((metadata #>> ARRAY['tags', '0']) = 'a')",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBNegativeIndex",
+			Queries: []string{
+				`input.object.metadata.tags[-1] == "a"`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBFractionIndex",
+			Queries: []string{
+				`input.object.metadata.tags[0.5] == "a"`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBBool",
+			Queries: []string{
+				`input.object.settings["feature"].enabled = true`,
+			},
+			ExpectedSQL:       "(CAST(settings #>> ARRAY['feature', 'enabled'] AS boolean) = true)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBVariableKey",
+			Queries: []string{
+				`input.object.settings[input.object.owner].enabled = true`,
+			},
+			ExpectedSQL:       "(CAST((settings -> owner) ->> 'enabled' AS boolean) = true)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBUnknownAttribute",
+			Queries: []string{
+				`input.object.metadata.missing = "x"`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "JSONBTypeMismatch",
+			Queries: []string{
+				`input.object.metadata.replicas = "3"`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		// Arrays
+		{
+			Name: "InEmptySet",
+			Queries: []string{
+				`input.object.owner in set()`,
+			},
+			ExpectedSQL:       "false",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "InEmptyArray",
+			Queries: []string{
+				`input.object.owner in []`,
+				`input.object.owner = "me"`,
+			},
+			ExpectedSQL:       "(owner = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "MixedNumbers",
+			Queries: []string{
+				`input.object.metadata.replicas in [1, 2.5, 3]`,
+			},
+			ExpectedSQL:       "(CAST(metadata ->> 'replicas' AS numeric) = ANY(ARRAY[1, 2.5, 3]))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "MixedTypesInJSONB",
+			Queries: []string{
+				`input.object.metadata.extra in ["a", 1, true]`,
+			},
+			ExpectedSQL:       "(EXISTS (SELECT 1 FROM jsonb_array_elements(jsonb_build_array('a', 1, true)) elem(value) WHERE elem.value = (metadata -> 'extra')))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "StringInMixedTypes",
+			Queries: []string{
+				`input.object.owner in ["a", 1]`,
+			},
+			ExpectedSQL:       "(jsonb_build_array('a', 1) ? owner)",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "EmptyArrayEquals",
+			Queries: []string{
+				`input.object.metadata.tags == []`,
+			},
+			ExpectedSQL:       "((metadata -> 'tags') = to_jsonb(ARRAY[]::text[]))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "CountArray",
+			Queries: []string{
+				`count(input.object.metadata.tags) >= 2`,
+				`count(input.object.owner) < 10`,
+			},
+			ExpectedSQL:       "(jsonb_array_length(metadata -> 'tags') >= 2) OR (char_length(owner) < 10)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "CountObject",
+			Queries: []string{
+				`count(input.object.metadata) > 0`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		// Objects
+		{
+			Name: "ObjectEquals",
+			Queries: []string{
+				`input.object.metadata.extra == {"env": "prod"}`,
+			},
+			ExpectedSQL:       "((metadata -> 'extra') = jsonb_build_object('env', 'prod'))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "NestedObjectEquals",
+			Queries: []string{
+				`input.object.metadata = {"replicas": 3, "labels": {"env": "prod"}}`,
+			},
+			ExpectedSQL:       "(metadata = jsonb_build_object('labels', jsonb_build_object('env', 'prod'), 'replicas', 3))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "ObjectInJSONB",
+			Queries: []string{
+				`{"k": "v"} in input.object.metadata.extra`,
+			},
+			ExpectedSQL:       "(EXISTS (SELECT 1 FROM jsonb_array_elements(metadata -> 'extra') elem(value) WHERE elem.value = jsonb_build_object('k', 'v')))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "ArrayInJSONB",
+			Queries: []string{
+				`["a"] in input.object.metadata.extra`,
+			},
+			ExpectedSQL:       "(EXISTS (SELECT 1 FROM jsonb_array_elements(metadata -> 'extra') elem(value) WHERE elem.value = to_jsonb(ARRAY['a'])))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "NumberInJSONB",
+			Queries: []string{
+				`3 in input.object.metadata.extra`,
+			},
+			ExpectedSQL:       "((metadata -> 'extra') @> jsonb_build_array(3))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "StringEqualsJSONB",
+			Queries: []string{
+				`input.object.metadata.extra != "x"`,
+			},
+			ExpectedSQL:       "((metadata -> 'extra') <> to_jsonb('x'::text))",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "ObjectNonStringKey",
+			Queries: []string{
+				`input.object.metadata.extra == {1: "a"}`,
+			},
+			ExpectError:       true,
+			VariableConverter: jsonbConverts(),
+		},
+		// Related tables
+		{
+			Name: "RelatedMember",
+			Queries: []string{
+				`"u1" in input.object.members`,
+			},
+			ExpectedSQL:       "(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1'))",
+			VariableConverter: relatedConverts(),
+		},
+		{
+			Name: "RelatedMembersSeparateRows",
+			Queries: []string{
+				`
+					"u1" in input.object.members
+					"u2" in input.object.members
+				`,
+			},
+			ExpectedSQL: "(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1') AND " +
+				"EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u2'))",
+			VariableConverter: relatedConverts(),
+		},
+		{
+			Name: "RelatedIteration",
+			Queries: []string{
+				`
+					input.object.members[i].user_id = "u1"
+					input.object.owner != ""
+					input.object.members[i].role = "admin"
+				`,
+			},
+			ExpectedSQL: "(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin') AND " +
+				"owner <> '')",
+			VariableConverter: relatedConverts(),
+		},
+		{
+			Name: "RelatedIterationTwice",
+			Queries: []string{
+				`input.object.members[i].user_id = input.object.members[j].user_id`,
+			},
+			ExpectError:       true,
+			VariableConverter: relatedConverts(),
+		},
+		// Table aliases
+		{
+			Name: "TableAlias",
+			Queries: []string{
+				`input.object.org_owner in {"a", "b"}`,
+				`"read" in input.object.acl_group_list[input.object.org_owner]`,
+			},
+			ExpectedSQL:       "(w.organization_id = ANY(ARRAY['a', 'b'])) OR ((w.group_acl -> w.organization_id) ? 'read')",
+			VariableConverter: defConverts(),
+			TableAlias:        "w",
+		},
+		{
+			Name: "TableAliasSchema",
+			Queries: []string{
+				`input.object.owner = "me"`,
+			},
+			ExpectedSQL:       "(public.workspaces.owner = 'me')",
+			VariableConverter: defConverts(),
+			TableAlias:        "workspaces",
+			Schema:            "public",
+		},
+		{
+			Name: "TableAliasesQualified",
+			Queries: []string{
+				`input.object.name = "foo"`,
+				`input.object.owner = "me"`,
+			},
+			ExpectedSQL: "(t.name = 'foo') OR (w.owner = 'me')",
+			VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+				rego2sql.StringVarMatcher([]string{"input", "object", "name"}, []string{"templates", "name"}, cty.UnknownVal(cty.String)),
+				rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+			),
+			TableAlias:   "w",
+			TableAliases: map[string]string{"templates": "t"},
+		},
+		{
+			Name: "TableAliasRelated",
+			Queries: []string{
+				`"u1" in input.object.members`,
+			},
+			ExpectedSQL:       "(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = 'u1'))",
+			VariableConverter: relatedConverts(),
+			TableAliases:      map[string]string{"workspaces": "w"},
+		},
+		// Local variables
+		{
+			Name: "AssignedVar",
+			Queries: []string{
+				`x := input.object.owner; x != ""; x in {"a", "b"}`,
+			},
+			ExpectedSQL:       "(owner <> '' AND owner = ANY(ARRAY['a', 'b']))",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "UnifiedVar",
+			Queries: []string{
+				`__local0__ = input.object.org_owner; "o" = __local0__`,
+			},
+			ExpectedSQL:       "('o' = organization_id)",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "UnifiedVarRight",
+			Queries: []string{
+				`input.object.org_owner = __local0__; "read" in input.object.acl_group_list[__local0__]`,
+			},
+			ExpectedSQL:       "((group_acl -> organization_id) ? 'read')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "VarUsedBeforeBinding",
+			Queries: []string{
+				`x != ""; x = input.object.owner`,
+			},
+			ExpectedSQL:       "(owner <> '')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "VarChain",
+			Queries: []string{
+				`y = x; x = input.object.owner; y = "me"`,
+			},
+			ExpectedSQL:       "(owner = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "VarRefHead",
+			Queries: []string{
+				`m := input.object.metadata; m.replicas > 3`,
+			},
+			ExpectedSQL:       "(CAST(metadata ->> 'replicas' AS numeric) > 3)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "VarCall",
+			Queries: []string{
+				`n := count(input.object.metadata.tags); n > 0`,
+			},
+			ExpectedSQL:       "(jsonb_array_length(metadata -> 'tags') > 0)",
+			VariableConverter: jsonbConverts(),
+		},
+		{
+			Name: "VarConstant",
+			Queries: []string{
+				`x := "me"; input.object.owner = x`,
+			},
+			ExpectedSQL:       "(owner = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "VarCycle",
+			Queries: []string{
+				`x = y; y = x; x = "a"`,
+			},
+			ExpectError:       true,
+			VariableConverter: defConverts(),
+		},
+		// Negation
+		{
+			Name: "Not",
+			Queries: []string{
+				`not input.object.owner = "me"`,
+			},
+			ExpectedSQL:       "(NOT owner = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "NotRelated",
+			Queries: []string{
+				`input.object.owner != ""; not "u1" in input.object.members`,
+			},
+			ExpectedSQL:       "(owner <> '' AND NOT EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1'))",
+			VariableConverter: relatedConverts(),
+		},
+		{
+			Name: "NotUnknownVar",
+			Queries: []string{
+				`input.object.owner = "me"; not "read" in input.object.acl_group_list.allUsers`,
+				`input.object.owner = "you"`,
+			},
+			ExpectedSQL:       "(owner = 'you')",
+			VariableConverter: noACLs(),
+			UnknownVarsFalse:  true,
+		},
+		{
+			// An unknown variable is not false, so negating it is not true.
+			Name: "NotUnknownVarOnly",
+			Queries: []string{
+				`not input.object.banned`,
+			},
+			ExpectedSQL:       "false",
+			VariableConverter: noACLs(),
+			UnknownVarsFalse:  true,
+		},
+		// With
+		{
+			Name: "WithConstant",
+			Queries: []string{
+				`input.object.owner = "me" with input.object.owner as "you"`,
+			},
+			ExpectedSQL:       "('you' = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "WithRef",
+			Queries: []string{
+				`input.object.owner = "me" with input.object.owner as input.object.org_owner`,
+			},
+			ExpectedSQL:       "(organization_id = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "WithObject",
+			Queries: []string{
+				`input.object.owner = input.object.org_owner with input.object as {"owner": "me"}`,
+			},
+			ExpectError:       true,
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "WithObjectLookup",
+			Queries: []string{
+				`input.object.owner = "me" with input.object as {"owner": "me"}`,
+			},
+			ExpectedSQL:       "('me' = 'me')",
+			VariableConverter: defConverts(),
+		},
+		{
+			Name: "WithData",
+			Queries: []string{
+				`input.object.owner = "me" with data.owner as "you"`,
+			},
+			ExpectError:       true,
+			VariableConverter: defConverts(),
+		},
+		// Network builtins
+		{
+			Name: "CIDRContainsColumnIP",
+			Queries: []string{
+				`net.cidr_contains("10.0.0.0/8", input.object.ip)`,
+			},
+			ExpectedSQL:       "('10.0.0.0/8'::inet >>= ip::inet)",
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRContainsColumnCIDR",
+			Queries: []string{
+				`net.cidr_contains(input.object.cidr, "10.1.2.3")`,
+			},
+			ExpectedSQL:       "(cidr::inet >>= '10.1.2.3'::inet)",
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRContainsColumns",
+			Queries: []string{
+				`net.cidr_contains(input.object.cidr, input.object.ip)`,
+			},
+			ExpectedSQL:       "(cidr::inet >>= ip::inet)",
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRContainsInvalid",
+			Queries: []string{
+				`net.cidr_contains("10.0.0.0", input.object.ip)`,
+			},
+			ExpectError:       true,
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRIntersects",
+			Queries: []string{
+				`net.cidr_intersects(input.object.cidr, "192.168.0.0/16")`,
+			},
+			ExpectedSQL:       "(cidr::inet && '192.168.0.0/16'::inet)",
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRIsValid",
+			Queries: []string{
+				`net.cidr_is_valid(input.object.cidr)`,
+			},
+			ExpectedSQL:       "((pg_input_is_valid(cidr, 'inet') AND strpos(cidr, '/') > 0))",
+			VariableConverter: netConverts(),
+		},
+		{
+			Name: "CIDRIsValidConst",
+			Queries: []string{
+				`net.cidr_is_valid("10.0.0.0/33")`,
+			},
+			ExpectedSQL: "false",
+		},
+		{
+			Name: "SessionString",
+			Queries: []string{
+				`input.object.owner = input.subject.id`,
+			},
+			ExpectedSQL:       "(owner = (NULLIF(current_setting('app.user_id', true), '')))",
+			VariableConverter: sessionConverts(),
+		},
+		{
+			Name: "SessionList",
+			Queries: []string{
+				`input.object.org_owner in input.subject.orgs`,
+			},
+			ExpectedSQL:       "(organization_id = ANY(string_to_array(NULLIF(current_setting('app.org_ids', true), ''), ',')))",
+			VariableConverter: sessionConverts(),
+		},
+		{
+			Name: "SessionNumbers",
+			Queries: []string{
+				`input.object.level <= input.subject.level`,
+				`input.object.level in input.subject.levels`,
+			},
+			ExpectedSQL:       "(level <= CAST(NULLIF(current_setting('app.level', true), '') AS numeric)) OR (level = ANY(string_to_array(NULLIF(current_setting('app.levels', true), ''), ',')::numeric[]))",
+			VariableConverter: sessionConverts(),
+		},
+		{
+			Name: "SessionBool",
+			Queries: []string{
+				`input.subject.admin = true`,
+			},
+			ExpectedSQL:       "(CAST(NULLIF(current_setting('app.admin', true), '') AS boolean) = true)",
+			VariableConverter: sessionConverts(),
+		},
+		{
+			Name: "SessionUnsupportedType",
+			Queries: []string{
+				`input.subject.roles.admin = "yes"`,
+			},
+			ExpectError:       true,
+			VariableConverter: sessionConverts(),
 		},
 	}
 
@@ -183,6 +795,9 @@ func TestRegoQueries(t *testing.T) {
 			cfg := rego2sql.ConvertConfig{
 				VariableConverter: tc.VariableConverter,
 				UnknownVarsFalse:  tc.UnknownVarsFalse,
+				TableAlias:        tc.TableAlias,
+				Schema:            tc.Schema,
+				TableAliases:      tc.TableAliases,
 			}
 
 			requireConvert(t, convertTestCase{
@@ -196,6 +811,40 @@ func TestRegoQueries(t *testing.T) {
 	}
 }
 
+func TestUnsupported(t *testing.T) {
+	t.Parallel()
+
+	cfg := rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+		),
+	}
+
+	testCases := []struct {
+		Query     string
+		Construct string
+	}{
+		{Query: `data.lib.is_owner(input.object.owner)`, Construct: "user-defined function"},
+		{Query: `input.object.owner = "me" with data.owner as "you"`, Construct: "with"},
+		{Query: `startswith(input.object.owner, "me")`, Construct: "startswith"},
+		{Query: `every x in input.object.owner { x }`, Construct: "every"},
+		{Query: `x != ""`, Construct: "var"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Construct, func(t *testing.T) {
+			t.Parallel()
+
+			body := ast.MustParseBodyWithOpts(tc.Query, ast.ParserOptions{AllFutureKeywords: true})
+			_, err := rego2sql.Convert(cfg, []ast.Body{body})
+			var unsupported *rego2sql.UnsupportedError
+			require.ErrorAs(t, err, &unsupported)
+			require.Equal(t, tc.Construct, unsupported.Construct)
+		})
+	}
+}
+
 type convertTestCase struct {
 	part *rego.PartialQueries
 	cfg  rego2sql.ConvertConfig
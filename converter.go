@@ -0,0 +1,303 @@
+package rego2sql
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+)
+
+// Converter converts queries with the same config, and is safe for concurrent
+// use. It is meant to be created once and shared, for services that convert
+// queries on every request:
+//   - The matchers are indexed by their rego path, see PathMatcher, instead
+//     of being tried in order for every ref.
+//   - The results are cached by the set of queries. Policies usually result
+//     in the same few sets of queries, one for each kind of subject. Queries
+//     that only differ in the names of the variables generated by partial
+//     evaluation, such as '__local0__', share an entry.
+//
+// The matchers of the config are read when the Converter is created, so
+// matchers registered afterwards are not used.
+type Converter struct {
+	cfg ConvertConfig
+
+	mu        sync.Mutex
+	cacheSize int
+	// entries is ordered by the last use, the most recent first.
+	entries *list.List
+	cache   map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key string
+	// done is closed once node and err are set. Concurrent lookups of the
+	// same queries wait for the one conversion.
+	done chan struct{}
+	node *pg_query.Node
+	err  error
+
+	// sql is serialized on first use by ConvertSQL.
+	sqlOnce sync.Once
+	sql     string
+	sqlErr  error
+}
+
+// NewConverter returns a Converter that caches up to cacheSize sets of
+// queries. A cacheSize of 0 disables the cache.
+func NewConverter(cfg ConvertConfig, cacheSize int) *Converter {
+	if cfg.VariableConverter != nil {
+		cfg.VariableConverter = newMatcherIndex(cfg.VariableConverter)
+	}
+	return &Converter{
+		cfg:       cfg,
+		cacheSize: cacheSize,
+		entries:   list.New(),
+		cache:     make(map[string]*list.Element),
+	}
+}
+
+// Convert is like the Convert function. The returned node is a copy, so it
+// can be modified. Copying the tree costs about as much as converting small
+// queries, so use ConvertSQL if only the SQL is needed.
+func (c *Converter) Convert(queries []ast.Body) (*pg_query.Node, error) {
+	entry := c.lookup(queries)
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	if c.cacheSize <= 0 {
+		// The entry is not shared.
+		return entry.node, nil
+	}
+	return proto.Clone(entry.node).(*pg_query.Node), nil
+}
+
+// ConvertSQL is like Convert followed by Serialize. The SQL is cached as
+// well, so it is only serialized once for each set of queries.
+func (c *Converter) ConvertSQL(queries []ast.Body) (string, error) {
+	entry := c.lookup(queries)
+	if entry.err != nil {
+		return "", entry.err
+	}
+	entry.sqlOnce.Do(func() {
+		entry.sql, entry.sqlErr = Serialize(entry.node)
+	})
+	return entry.sql, entry.sqlErr
+}
+
+// lookup returns the cached conversion of the queries, or converts and caches
+// them. Cached entries are shared, and must not be modified.
+func (c *Converter) lookup(queries []ast.Body) *cacheEntry {
+	if c.cacheSize <= 0 {
+		c.misses.Add(1)
+		node, err := convert(c.cfg, queries, &Diagnostics{}, nil)
+		return &cacheEntry{node: node, err: err}
+	}
+
+	key := cacheKey(queries)
+	c.mu.Lock()
+	if elem, ok := c.cache[key]; ok {
+		c.entries.MoveToFront(elem)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		entry := elem.Value.(*cacheEntry)
+		<-entry.done
+		return entry
+	}
+	// The entry is added before converting, so concurrent lookups of the
+	// same queries wait for it instead of converting them again.
+	entry := &cacheEntry{key: key, done: make(chan struct{})}
+	c.cache[key] = c.entries.PushFront(entry)
+	for c.entries.Len() > c.cacheSize {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.cache, oldest.Value.(*cacheEntry).key)
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	defer close(entry.done)
+	entry.node, entry.err = convert(c.cfg, queries, &Diagnostics{}, nil)
+	return entry
+}
+
+// cacheKey normalizes the queries. The order of the queries does not change
+// the result, as they are OR'd. Errors are cached with the key, so the error
+// of queries that only differ in generated variable names names the
+// variables of the first of them converted.
+func cacheKey(queries []ast.Body) string {
+	bodies := make([]string, 0, len(queries))
+	for _, q := range queries {
+		bodies = append(bodies, normalizeVars(q).String())
+	}
+	sort.Strings(bodies)
+	return strings.Join(bodies, "\n")
+}
+
+// normalizeVars renumbers the variables generated by partial evaluation, such
+// as '__local3__', in the order they appear in the query. The numbers depend
+// on what else was evaluated, so the same query can be generated with
+// different names.
+func normalizeVars(q ast.Body) ast.Body {
+	names := make(map[ast.Var]ast.Var)
+	ast.WalkVars(q, func(v ast.Var) bool {
+		if _, ok := names[v]; v.IsGenerated() && !ok {
+			names[v] = ast.Var(fmt.Sprintf("__local%d__", len(names)))
+		}
+		return false
+	})
+	if len(names) == 0 {
+		return q
+	}
+
+	renamed, err := ast.TransformVars(q.Copy(), func(v ast.Var) (ast.Value, error) {
+		if name, ok := names[v]; ok {
+			return name, nil
+		}
+		return v, nil
+	})
+	if err != nil {
+		// The transform never returns an error.
+		return q
+	}
+	return renamed.(ast.Body)
+}
+
+type ConverterStats struct {
+	// Hits and Misses count the conversions found in the cache, and the
+	// ones that were converted.
+	Hits   uint64
+	Misses uint64
+	// Entries is the number of cached sets of queries.
+	Entries int
+}
+
+// HitRate is the fraction of the conversions found in the cache.
+func (s ConverterStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (c *Converter) Stats() ConverterStats {
+	c.mu.Lock()
+	entries := c.entries.Len()
+	c.mu.Unlock()
+	return ConverterStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// matcherIndex is a trie of matchers by their rego path. It matches the same
+// refs as the VariableConverter it is created from, in the same order.
+type matcherIndex struct {
+	root *matcherNode
+}
+
+type matcherNode struct {
+	children map[string]*matcherNode
+	// matchers whose path ends at this node.
+	matchers []indexedMatcher
+}
+
+type indexedMatcher struct {
+	// order is the position of the matcher in the VariableConverter, as the
+	// first matcher to match a ref is used.
+	order   int
+	matcher VariableMatcher
+}
+
+func newMatcherIndex(m VariableMatcher) *matcherIndex {
+	idx := &matcherIndex{root: &matcherNode{}}
+	order := 0
+	visited := make(map[*VariableConverter]bool)
+
+	var add func(m VariableMatcher)
+	add = func(m VariableMatcher) {
+		if vc, ok := m.(*VariableConverter); ok {
+			// Nested converters are flattened, keeping the order.
+			if visited[vc] {
+				return
+			}
+			visited[vc] = true
+			for _, nested := range vc.converters {
+				add(nested)
+			}
+			return
+		}
+
+		node := idx.root
+		for _, part := range matchPath(m) {
+			child, ok := node.children[part]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*matcherNode)
+				}
+				child = &matcherNode{}
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.matchers = append(node.matchers, indexedMatcher{order: order, matcher: m})
+		order++
+	}
+	add(m)
+	return idx
+}
+
+func (idx *matcherIndex) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	_, n, ok := idx.MatchVariable(rego)
+	return n, ok
+}
+
+// MatchVariable tries the matchers whose path is a prefix of the ref, in the
+// order they were registered.
+func (idx *matcherIndex) MatchVariable(rego ast.Ref) (VariableMatcher, *Item, bool) {
+	// Copied, as the index is shared by concurrent conversions.
+	candidates := append([]indexedMatcher(nil), idx.root.matchers...)
+	node := idx.root
+	for i, term := range rego {
+		var part string
+		switch v := term.Value.(type) {
+		case ast.Var:
+			if i > 0 {
+				break
+			}
+			part = string(v)
+		case ast.String:
+			if i == 0 {
+				break
+			}
+			part = string(v)
+		}
+		if part == "" {
+			break
+		}
+
+		node = node.children[part]
+		if node == nil {
+			break
+		}
+		candidates = append(candidates, node.matchers...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].order < candidates[j].order })
+
+	for _, c := range candidates {
+		if n, ok := c.matcher.ConvertVariable(rego); ok {
+			return c.matcher, n, true
+		}
+	}
+	return nil, nil, false
+}
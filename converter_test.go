@@ -0,0 +1,272 @@
+package rego2sql_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConverter(t *testing.T) {
+	t.Parallel()
+
+	workspaces := rego2sql.ConvertConfig{VariableConverter: codercfg.WorkspaceConverter(), TableAlias: "w"}
+
+	t.Run("SameAsConvert", func(t *testing.T) {
+		t.Parallel()
+
+		// The first matcher to match is used, even if a later one has a
+		// longer path.
+		overlapping := rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.NewJSONBPathMatcher(nil, []string{"input", "object", "labels"}, []string{"labels"}, cty.Map(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "labels", "env"}, []string{"env"}, cty.UnknownVal(cty.String)),
+			rego2sql.NewVariableConverter().RegisterMatcher(
+				rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+			),
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"other_owner_id"}, cty.UnknownVal(cty.String)),
+			rego2sql.SessionVarMatcher([]string{"input", "subject", "id"}, "app.user_id", cty.String),
+		)
+
+		testCases := []struct {
+			Name    string
+			Config  rego2sql.ConvertConfig
+			Queries []string
+		}{
+			{
+				Name:    "Workspaces",
+				Config:  workspaces,
+				Queries: []string{`input.object.owner = "u1"`, `"read" in input.object.acl_group_list[_]`, `input.object.org_owner in ["o1", "o2"]`},
+			},
+			{
+				Name:    "Overlapping",
+				Config:  rego2sql.ConvertConfig{VariableConverter: overlapping},
+				Queries: []string{`input.object.labels.env = "prod"; input.object.owner = input.subject.id`},
+			},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				t.Parallel()
+
+				part := partialQueries(t, tc.Queries...)
+				expected, err := rego2sql.Convert(tc.Config, part.Queries)
+				require.NoError(t, err)
+				expectedSQL, err := rego2sql.Serialize(expected)
+				require.NoError(t, err)
+
+				node, err := rego2sql.NewConverter(tc.Config, 10).Convert(part.Queries)
+				require.NoError(t, err)
+				sql, err := rego2sql.Serialize(node)
+				require.NoError(t, err)
+				require.Equal(t, expectedSQL, sql)
+			})
+		}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		t.Parallel()
+
+		c := rego2sql.NewConverter(workspaces, 2)
+		first := partialQueries(t, `input.object.owner = "u1"`, `input.object.org_owner = "o1"`).Queries
+		reordered := []ast.Body{first[1], first[0]}
+
+		node, err := c.Convert(first)
+		require.NoError(t, err)
+		// The cached node is a copy, so modifying it does not change the
+		// next result.
+		node.GetBoolExpr().Args = nil
+
+		node, err = c.Convert(reordered)
+		require.NoError(t, err)
+		sql, err := rego2sql.Serialize(node)
+		require.NoError(t, err)
+		require.Equal(t, "(w.owner_id::text = 'u1') OR (w.organization_id::text = 'o1')", sql)
+
+		sql, err = c.ConvertSQL(first)
+		require.NoError(t, err)
+		require.Equal(t, "(w.owner_id::text = 'u1') OR (w.organization_id::text = 'o1')", sql)
+		require.Equal(t, rego2sql.ConverterStats{Hits: 2, Misses: 1, Entries: 1}, c.Stats())
+
+		// Errors are cached as well.
+		unsupported := partialQueries(t, `startswith(input.object.owner, "u")`).Queries
+		_, err = c.Convert(unsupported)
+		require.Error(t, err)
+		_, err = c.ConvertSQL(unsupported)
+		require.Error(t, err)
+		require.Equal(t, rego2sql.ConverterStats{Hits: 3, Misses: 2, Entries: 2}, c.Stats())
+
+		// The least recently used entry is evicted.
+		_, err = c.Convert(partialQueries(t, `input.object.owner = "u2"`).Queries)
+		require.NoError(t, err)
+		_, err = c.Convert(unsupported)
+		require.Error(t, err)
+		_, err = c.Convert(first)
+		require.NoError(t, err)
+		stats := c.Stats()
+		require.Equal(t, rego2sql.ConverterStats{Hits: 4, Misses: 4, Entries: 2}, stats)
+		require.InDelta(t, 0.5, stats.HitRate(), 0.0001)
+	})
+
+	t.Run("GeneratedVars", func(t *testing.T) {
+		t.Parallel()
+
+		// Partial evaluation numbers the variables it generates, so the
+		// same query can be generated with different names.
+		c := rego2sql.NewConverter(workspaces, 2)
+		sql, err := c.ConvertSQL(partialQueries(t, `__local0__ = input.object.org_owner; "o" = __local0__`).Queries)
+		require.NoError(t, err)
+		require.Equal(t, "('o' = w.organization_id::text)", sql)
+
+		sql, err = c.ConvertSQL(partialQueries(t, `__local7__ = input.object.org_owner; "o" = __local7__`).Queries)
+		require.NoError(t, err)
+		require.Equal(t, "('o' = w.organization_id::text)", sql)
+		require.Equal(t, rego2sql.ConverterStats{Hits: 1, Misses: 1, Entries: 1}, c.Stats())
+
+		// Variables that are not generated are not renamed.
+		_, err = c.ConvertSQL(partialQueries(t, `x = input.object.org_owner; "o" = x`).Queries)
+		require.NoError(t, err)
+		require.Equal(t, rego2sql.ConverterStats{Hits: 1, Misses: 2, Entries: 2}, c.Stats())
+	})
+
+	t.Run("NoCache", func(t *testing.T) {
+		t.Parallel()
+
+		c := rego2sql.NewConverter(workspaces, 0)
+		queries := partialQueries(t, `input.object.owner = "u1"`).Queries
+		for i := 0; i < 2; i++ {
+			_, err := c.Convert(queries)
+			require.NoError(t, err)
+		}
+		require.Equal(t, rego2sql.ConverterStats{Misses: 2}, c.Stats())
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		sets := [][]ast.Body{
+			partialQueries(t, `input.object.owner = "u1"`).Queries,
+			partialQueries(t, `input.object.org_owner in ["o1", "o2"]`).Queries,
+			partialQueries(t, `"read" in input.object.acl_user_list.u1`, `input.object.owner = "u1"`).Queries,
+		}
+		expected := make([]string, 0, len(sets))
+		for _, set := range sets {
+			node, err := rego2sql.Convert(workspaces, set)
+			require.NoError(t, err)
+			sql, err := rego2sql.Serialize(node)
+			require.NoError(t, err)
+			expected = append(expected, sql)
+		}
+
+		c := rego2sql.NewConverter(workspaces, 10)
+		const workers, conversions = 8, 50
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*conversions)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < conversions; i++ {
+					set := (w + i) % len(sets)
+					if i%2 == 0 {
+						sql, err := c.ConvertSQL(sets[set])
+						if err == nil && sql != expected[set] {
+							err = fmt.Errorf("got %s, expected %s", sql, expected[set])
+						}
+						errs <- err
+						continue
+					}
+					node, err := c.Convert(sets[set])
+					if err == nil {
+						err = checkSQL(node, expected[set])
+					}
+					errs <- err
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		// Each set is converted once, concurrent lookups wait for it.
+		require.Equal(t, rego2sql.ConverterStats{
+			Hits:    uint64(workers*conversions - len(sets)),
+			Misses:  uint64(len(sets)),
+			Entries: len(sets),
+		}, c.Stats())
+	})
+}
+
+func checkSQL(node *pg_query.Node, expected string) error {
+	sql, err := rego2sql.Serialize(node)
+	if err != nil {
+		return err
+	}
+	if sql != expected {
+		return fmt.Errorf("got %s, expected %s", sql, expected)
+	}
+	return nil
+}
+
+func BenchmarkConvert(b *testing.B) {
+	cfg := rego2sql.ConvertConfig{VariableConverter: codercfg.WorkspaceConverter()}
+	queries := []ast.Body{
+		ast.MustParseBody(`input.object.owner = "u1"`),
+		ast.MustParseBody(`input.object.org_owner = "o1"; input.object.acl_group_list.g1[_] = "read"`),
+	}
+
+	b.Run("Convert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := rego2sql.Convert(cfg, queries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ConvertSerialize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			node, err := rego2sql.Convert(cfg, queries)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := rego2sql.Serialize(node); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ConverterNoCache", func(b *testing.B) {
+		c := rego2sql.NewConverter(cfg, 0)
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Convert(queries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Converter", func(b *testing.B) {
+		c := rego2sql.NewConverter(cfg, 16)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := c.Convert(queries); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+	b.Run("ConverterSQL", func(b *testing.B) {
+		c := rego2sql.NewConverter(cfg, 16)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := c.ConvertSQL(queries); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
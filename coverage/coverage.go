@@ -0,0 +1,150 @@
+// Package coverage reports which parts of a policy cannot be converted to SQL.
+// The policy is partially evaluated for a set of representative inputs, and
+// every residual expression that cannot be converted is grouped by the builtin
+// or construct that is not supported.
+package coverage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Emyrk/rego2sql"
+)
+
+// maxExamples is the number of examples kept for each construct.
+const maxExamples = 3
+
+type Config struct {
+	// Partial is used for every input. Its Input is replaced by each of the
+	// Inputs.
+	Partial rego2sql.PartialConfig
+	Convert rego2sql.ConvertConfig
+	// Inputs are the representative inputs, such as one for each role.
+	Inputs []any
+}
+
+type Report struct {
+	Inputs  int `json:"inputs"`
+	Queries int `json:"queries"`
+	// Exprs is the number of residual expressions of all queries, and
+	// Unconvertible how many of them could not be converted.
+	Exprs         int `json:"exprs"`
+	Unconvertible int `json:"unconvertible"`
+	// SupportModules is the number of inputs the policy could not be
+	// partially evaluated for without support modules. They have no queries,
+	// so they are not counted in Exprs or Unconvertible.
+	SupportModules int `json:"support_modules"`
+	// Constructs are sorted by count, the most common first.
+	Constructs []Construct `json:"constructs"`
+}
+
+// Construct is a builtin or construct that could not be converted.
+type Construct struct {
+	Name string `json:"name"`
+	// Count is the number of expressions, for all inputs.
+	Count    int       `json:"count"`
+	Examples []Example `json:"examples"`
+}
+
+type Example struct {
+	// Input is the index of the input the expression is from.
+	Input    int    `json:"input"`
+	Rego     string `json:"rego"`
+	Location string `json:"location,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Analyze partially evaluates the policy for each input, and reports the
+// expressions that cannot be converted. Inputs the policy cannot be fully
+// inlined for are reported as the 'support module' construct.
+func Analyze(ctx context.Context, cfg Config) (*Report, error) {
+	report := &Report{Inputs: len(cfg.Inputs), Constructs: []Construct{}}
+	constructs := make(map[string]*Construct)
+	add := func(name string, example Example) {
+		c, ok := constructs[name]
+		if !ok {
+			c = &Construct{Name: name}
+			constructs[name] = c
+		}
+		c.Count++
+		if len(c.Examples) >= maxExamples {
+			return
+		}
+		for _, e := range c.Examples {
+			if e.Rego == example.Rego && e.Location == example.Location {
+				return
+			}
+		}
+		c.Examples = append(c.Examples, example)
+	}
+
+	for i, input := range cfg.Inputs {
+		partial := cfg.Partial
+		partial.Input = input
+		queries, err := rego2sql.Partial(ctx, partial)
+		if errors.Is(err, rego2sql.ErrSupportModules) {
+			report.SupportModules++
+			add("support module", Example{Input: i, Rego: partial.Query, Reason: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		report.Queries += len(queries)
+		for _, q := range queries {
+			report.Exprs += len(q)
+		}
+		for _, u := range rego2sql.FindUnconvertible(cfg.Convert, queries) {
+			report.Unconvertible++
+			add(u.Construct, Example{
+				Input:    i,
+				Rego:     u.Rego,
+				Location: rego2sql.FormatLocation(u.Location),
+				Reason:   u.Err.Error(),
+			})
+		}
+	}
+
+	for _, c := range constructs {
+		report.Constructs = append(report.Constructs, *c)
+	}
+	sort.Slice(report.Constructs, func(i, j int) bool {
+		a, b := report.Constructs[i], report.Constructs[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+// Write writes the report as text.
+func (r *Report) Write(w io.Writer) error {
+	summary := fmt.Sprintf("%d inputs, %d queries, %d expressions, %d could not be converted",
+		r.Inputs, r.Queries, r.Exprs, r.Unconvertible)
+	if r.SupportModules > 0 {
+		summary += fmt.Sprintf(", %d inputs need support modules", r.SupportModules)
+	}
+	if _, err := fmt.Fprintln(w, summary); err != nil {
+		return err
+	}
+	for _, c := range r.Constructs {
+		if _, err := fmt.Fprintf(w, "\n%s: %d\n", c.Name, c.Count); err != nil {
+			return err
+		}
+		for _, e := range c.Examples {
+			loc := e.Location
+			if loc == "" {
+				loc = "-"
+			}
+			if _, err := fmt.Fprintf(w, "  %s: %s (input %d)\n    %s\n", loc, e.Rego, e.Input, e.Reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package coverage_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/coverage"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const policy = `package authz
+
+default allow := false
+
+allow if input.object.owner == input.subject.id
+
+allow if {
+	input.subject.role == "dev"
+	startswith(input.object.name, "dev-")
+}
+
+allow if {
+	input.subject.role == "ops"
+	input.object.region == "eu"
+	startswith(input.object.name, "ops-")
+}
+`
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+
+	cfg := coverage.Config{
+		Partial: rego2sql.PartialConfig{
+			Query:    "data.authz.allow = true",
+			Modules:  map[string]string{"policy.rego": policy},
+			Unknowns: []string{"input.object"},
+		},
+		Convert: rego2sql.ConvertConfig{
+			VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+				rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+				rego2sql.StringVarMatcher([]string{"input", "object", "name"}, []string{"name"}, cty.UnknownVal(cty.String)),
+			),
+		},
+		Inputs: []any{
+			map[string]any{"subject": map[string]any{"id": "u1", "role": "dev"}},
+			map[string]any{"subject": map[string]any{"id": "u2", "role": "ops"}},
+			map[string]any{"subject": map[string]any{"id": "u3", "role": "dev"}},
+		},
+	}
+
+	t.Run("Report", func(t *testing.T) {
+		t.Parallel()
+
+		report, err := coverage.Analyze(context.Background(), cfg)
+		require.NoError(t, err)
+		require.Equal(t, &coverage.Report{
+			Inputs:        3,
+			Queries:       6,
+			Exprs:         7,
+			Unconvertible: 4,
+			Constructs: []coverage.Construct{
+				{
+					Name:  "startswith",
+					Count: 3,
+					Examples: []coverage.Example{
+						{
+							Input:    0,
+							Rego:     `startswith(input.object.name, "dev-")`,
+							Location: "policy.rego:9:2",
+							Reason:   `convert call startswith(input.object.name, "dev-"): policy.rego:9:2: startswith is not supported`,
+						},
+						{
+							Input:    1,
+							Rego:     `startswith(input.object.name, "ops-")`,
+							Location: "policy.rego:15:2",
+							Reason:   `convert call startswith(input.object.name, "ops-"): policy.rego:15:2: startswith is not supported`,
+						},
+					},
+				},
+				{
+					Name:  "ref",
+					Count: 1,
+					Examples: []coverage.Example{{
+						Input:    1,
+						Rego:     `input.object.region = "eu"`,
+						Location: "policy.rego:14:2",
+						Reason:   `convert call input.object.region = "eu": arguments: term: variable "input.object.region" cannot be converted: unknown variable`,
+					}},
+				},
+			},
+		}, report)
+
+		var buf bytes.Buffer
+		require.NoError(t, report.Write(&buf))
+		require.Equal(t, `3 inputs, 6 queries, 7 expressions, 4 could not be converted
+
+startswith: 3
+  policy.rego:9:2: startswith(input.object.name, "dev-") (input 0)
+    convert call startswith(input.object.name, "dev-"): policy.rego:9:2: startswith is not supported
+  policy.rego:15:2: startswith(input.object.name, "ops-") (input 1)
+    convert call startswith(input.object.name, "ops-"): policy.rego:15:2: startswith is not supported
+
+ref: 1
+  policy.rego:14:2: input.object.region = "eu" (input 1)
+    convert call input.object.region = "eu": arguments: term: variable "input.object.region" cannot be converted: unknown variable
+`, buf.String())
+	})
+
+	t.Run("SupportModules", func(t *testing.T) {
+		t.Parallel()
+
+		c := cfg
+		c.Partial.Query = "data.authz.allow"
+		report, err := coverage.Analyze(context.Background(), c)
+		require.NoError(t, err)
+		require.Len(t, report.Constructs, 1)
+		require.Equal(t, "support module", report.Constructs[0].Name)
+		require.Equal(t, 3, report.Constructs[0].Count)
+		require.Equal(t, 3, report.SupportModules)
+		require.Zero(t, report.Exprs)
+		require.Zero(t, report.Unconvertible)
+
+		var buf bytes.Buffer
+		require.NoError(t, report.Write(&buf))
+		require.Contains(t, buf.String(), "3 inputs, 0 queries, 0 expressions, 0 could not be converted, 3 inputs need support modules\n")
+	})
+}
@@ -0,0 +1,73 @@
+package rego2sql
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// Diagnostics describe the parts of the rego queries that are not in the
+// converted SQL, so unexpected filters can be debugged.
+type Diagnostics struct {
+	// Dropped are the queries and expressions removed from the SQL, as their
+	// result is known.
+	Dropped []Dropped
+	// Warnings are conversions that are valid, but likely unintended, such
+	// as a filter that matches every row.
+	Warnings []string
+	// Matches are the matchers that converted each ref.
+	Matches []Match
+}
+
+type Match struct {
+	// Query is the index of the query the ref is from.
+	Query   int    `json:"query"`
+	Ref     string `json:"ref"`
+	Matcher string `json:"matcher"`
+}
+
+type Dropped struct {
+	// Query is the index of the query the rego is from.
+	Query int    `json:"query"`
+	Rego  string `json:"rego"`
+	// Reason the rego was dropped.
+	Reason string `json:"reason"`
+}
+
+func (d *Diagnostics) drop(query int, rego string, reason string, args ...any) {
+	d.Dropped = append(d.Dropped, Dropped{
+		Query:  query,
+		Rego:   rego,
+		Reason: fmt.Sprintf(reason, args...),
+	})
+}
+
+// match records the matcher of the ref, once per query.
+func (d *Diagnostics) match(query int, ref ast.Ref, m VariableMatcher) {
+	for _, existing := range d.Matches {
+		if existing.Query == query && existing.Ref == ref.String() {
+			return
+		}
+	}
+	d.Matches = append(d.Matches, Match{
+		Query:   query,
+		Ref:     ref.String(),
+		Matcher: DescribeMatcher(m),
+	})
+}
+
+func (d *Diagnostics) warn(msg string, args ...any) {
+	d.Warnings = append(d.Warnings, fmt.Sprintf(msg, args...))
+}
+
+// ConvertWithDiagnostics is like Convert, but also returns the diagnostics of
+// the conversion.
+func ConvertWithDiagnostics(cfg ConvertConfig, queries []ast.Body) (*pg_query.Node, *Diagnostics, error) {
+	diags := &Diagnostics{}
+	node, err := convert(cfg, queries, diags, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, diags, nil
+}
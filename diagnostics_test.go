@@ -0,0 +1,96 @@
+package rego2sql_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name             string
+		Queries          []string
+		ExpectedSQL      string
+		ExpectedDropped  []rego2sql.Dropped
+		ExpectedWarnings []string
+		ExpectedMatches  []rego2sql.Match
+	}{
+		{
+			Name: "DroppedQuery",
+			Queries: []string{
+				`input.object.owner = "u1"`,
+				`"read" in input.object.acl_user_list.u1`,
+			},
+			ExpectedSQL: "(id::text = 'u1')",
+			ExpectedDropped: []rego2sql.Dropped{{
+				Query:  1,
+				Rego:   `internal.member_2("read", input.object.acl_user_list.u1)`,
+				Reason: `the query is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false`,
+			}},
+			ExpectedMatches: []rego2sql.Match{
+				{Query: 0, Ref: "input.object.owner", Matcher: "column id::text"},
+				{Query: 1, Ref: "input.object.acl_user_list.u1", Matcher: "always false (acl column user_acl)"},
+			},
+		},
+		{
+			Name: "DroppedNegation",
+			Queries: []string{
+				`input.object.owner = "u1"; not "read" in input.object.acl_user_list.u1`,
+			},
+			ExpectedSQL: "(id::text = 'u1')",
+			ExpectedDropped: []rego2sql.Dropped{{
+				Query:  0,
+				Rego:   `not internal.member_2("read", input.object.acl_user_list.u1)`,
+				Reason: `the negated expression is always false: convert call not internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false`,
+			}},
+			ExpectedMatches: []rego2sql.Match{
+				{Query: 0, Ref: "input.object.owner", Matcher: "column id::text"},
+				{Query: 0, Ref: "input.object.acl_user_list.u1", Matcher: "always false (acl column user_acl)"},
+			},
+		},
+		{
+			Name:             "AllDropped",
+			Queries:          []string{`"read" in input.object.acl_user_list.u1`},
+			ExpectedSQL:      "false",
+			ExpectedWarnings: []string{"every query is always false, the filter matches no rows"},
+			ExpectedMatches: []rego2sql.Match{
+				{Query: 0, Ref: "input.object.acl_user_list.u1", Matcher: "always false (acl column user_acl)"},
+			},
+			ExpectedDropped: []rego2sql.Dropped{{
+				Query:  0,
+				Rego:   `internal.member_2("read", input.object.acl_user_list.u1)`,
+				Reason: `the query is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false`,
+			}},
+		},
+		{
+			Name:             "AlwaysTrue",
+			Queries:          []string{`input.object.owner = "u1"`, ``},
+			ExpectedSQL:      "true",
+			ExpectedWarnings: []string{"query 1 is always true, the filter matches every row"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			part := partialQueries(t, tc.Queries...)
+			node, diags, err := rego2sql.ConvertWithDiagnostics(rego2sql.ConvertConfig{
+				VariableConverter: codercfg.UserConverter(),
+			}, part.Queries)
+			require.NoError(t, err)
+
+			sql, err := rego2sql.Serialize(node)
+			require.NoError(t, err)
+			require.Equal(t, tc.ExpectedSQL, sql)
+			require.Equal(t, tc.ExpectedDropped, diags.Dropped)
+			require.Equal(t, tc.ExpectedWarnings, diags.Warnings)
+			require.Equal(t, tc.ExpectedMatches, diags.Matches)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// Package difftest checks that the SQL generated for a policy filters the same
+// rows the policy allows. The rows are loaded into an in-memory SQLite
+// database, and the filter is compared to evaluating the policy with OPA for
+// each row.
+//
+// The SQL is generated for postgres, so only filters that SQLite can run are
+// supported. Casts are removed, as SQLite is dynamically typed, and
+// 'x = ANY(ARRAY[...])' is rewritten to 'x IN (...)'. JSONB operators and
+// postgres functions are not supported.
+package difftest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+
+	// The pure go SQLite driver, registered as "sqlite".
+	_ "github.com/glebarez/go-sqlite"
+)
+
+type Config struct {
+	// Query is evaluated, such as 'data.authz.allow = true'.
+	Query string
+	// Modules maps file names to rego source.
+	Modules     map[string]string
+	RegoVersion ast.RegoVersion
+	// Input is the known input, such as the subject. It must be an object.
+	Input map[string]any
+	// Unknown is the ref each row is placed at in the input. It is left
+	// unknown during partial evaluation, and defaults to 'input.object'.
+	Unknown string
+	Convert rego2sql.ConvertConfig
+
+	// Setup are the SQLite statements run before the rows are inserted, such
+	// as creating the tables and inserting rows of related tables.
+	Setup []string
+	// Table the rows are inserted into. The filter is applied to it.
+	Table string
+	Rows  []Row
+}
+
+// Row is a fixture row. The same row is given twice, as the columns of the
+// table and as the rego object the columns are matched to.
+type Row struct {
+	// Columns are inserted into the table.
+	Columns map[string]any
+	// Object is placed at the Unknown ref of the input when evaluating the
+	// policy.
+	Object map[string]any
+}
+
+// Mismatch is a row the policy and the SQL do not agree on.
+type Mismatch struct {
+	// Row is the index of the row.
+	Row int
+	// Allowed is the result of evaluating the policy.
+	Allowed bool
+	// Filtered is true if the SQL returned the row.
+	Filtered bool
+}
+
+func (m Mismatch) String() string {
+	if m.Allowed {
+		return fmt.Sprintf("row %d is allowed by the policy, but not returned by the SQL", m.Row)
+	}
+	return fmt.Sprintf("row %d is denied by the policy, but returned by the SQL", m.Row)
+}
+
+type Result struct {
+	// SQL is the filter, as it was run on SQLite.
+	SQL        string
+	Mismatches []Mismatch
+}
+
+// Run converts the policy to SQL, and compares the rows it returns to the rows
+// the policy allows.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("table is required")
+	}
+	if cfg.Unknown == "" {
+		cfg.Unknown = "input.object"
+	}
+	unknown, err := ast.ParseRef(cfg.Unknown)
+	if err != nil {
+		return nil, fmt.Errorf("parse unknown: %w", err)
+	}
+	if !unknown.HasPrefix(ast.InputRootRef) || len(unknown) < 2 {
+		return nil, fmt.Errorf("unknown %s must be a field of the input", unknown)
+	}
+	for _, term := range unknown[1:] {
+		if _, ok := term.Value.(ast.String); !ok {
+			return nil, fmt.Errorf("unknown %s must only have string keys", unknown)
+		}
+	}
+
+	partial := rego2sql.PartialConfig{
+		Query:       cfg.Query,
+		Modules:     cfg.Modules,
+		Unknowns:    []string{cfg.Unknown},
+		RegoVersion: cfg.RegoVersion,
+	}
+	if cfg.Input != nil {
+		partial.Input = cfg.Input
+	}
+	queries, err := rego2sql.Partial(ctx, partial)
+	if err != nil {
+		return nil, err
+	}
+	node, err := rego2sql.Convert(cfg.Convert, queries)
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	node, err = sqliteFilter(node)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := rego2sql.Serialize(node)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+
+	filtered, err := queryRows(ctx, cfg, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{SQL: filter}
+	for i, row := range cfg.Rows {
+		allowed, err := evalRow(ctx, cfg, unknown, row)
+		if err != nil {
+			return nil, fmt.Errorf("eval row %d: %w", i, err)
+		}
+		if allowed != filtered[i] {
+			result.Mismatches = append(result.Mismatches, Mismatch{
+				Row:      i,
+				Allowed:  allowed,
+				Filtered: filtered[i],
+			})
+		}
+	}
+	return result, nil
+}
+
+// queryRows loads the rows into SQLite, and returns which rows the filter
+// returned.
+func queryRows(ctx context.Context, cfg Config, filter string) ([]bool, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	defer db.Close()
+	// Each connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range cfg.Setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("setup %q: %w", stmt, err)
+		}
+	}
+
+	// The rowid of each row, to find which rows are returned.
+	rowIDs := make(map[int64]int, len(cfg.Rows))
+	for i, row := range cfg.Rows {
+		columns := make([]string, 0, len(row.Columns))
+		for column := range row.Columns {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		args := make([]any, 0, len(columns))
+		for _, column := range columns {
+			args = append(args, row.Columns[column])
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", cfg.Table,
+			strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+		res, err := db.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return nil, fmt.Errorf("insert row %d: %w", i, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("insert row %d: %w", i, err)
+		}
+		rowIDs[id] = i
+	}
+
+	from := cfg.Table
+	if cfg.Convert.TableAlias != "" {
+		from += " AS " + cfg.Convert.TableAlias
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT rowid FROM %s WHERE %s", from, filter))
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", filter, err)
+	}
+	defer rows.Close()
+
+	filtered := make([]bool, len(cfg.Rows))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		// Rows inserted by Setup are not fixture rows.
+		if i, ok := rowIDs[id]; ok {
+			filtered[i] = true
+		}
+	}
+	return filtered, rows.Err()
+}
+
+// evalRow evaluates the policy with the row in the input. The row is allowed
+// if the query has any result.
+func evalRow(ctx context.Context, cfg Config, unknown ast.Ref, row Row) (bool, error) {
+	input := withField(cfg.Input, unknown[1:], row.Object)
+
+	opts := []func(*rego.Rego){
+		rego.Query(cfg.Query),
+		rego.Input(input),
+	}
+	if cfg.RegoVersion != ast.RegoUndefined {
+		opts = append(opts, rego.SetRegoVersion(cfg.RegoVersion))
+	}
+	for name, module := range cfg.Modules {
+		opts = append(opts, rego.Module(name, module))
+	}
+
+	rs, err := rego.New(opts...).Eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(rs) > 0, nil
+}
+
+// withField returns a copy of the object with the field at the path set. The
+// objects along the path are copied, so the original is not modified.
+func withField(obj map[string]any, path ast.Ref, value any) map[string]any {
+	cp := make(map[string]any, len(obj)+1)
+	for k, v := range obj {
+		cp[k] = v
+	}
+
+	key := string(path[0].Value.(ast.String))
+	if len(path) == 1 {
+		cp[key] = value
+		return cp
+	}
+	child, _ := cp[key].(map[string]any)
+	cp[key] = withField(child, path[1:], value)
+	return cp
+}
+
+// sqliteFilter rewrites the postgres specific parts of the filter for SQLite.
+func sqliteFilter(n *pg_query.Node) (*pg_query.Node, error) {
+	n = proto.Clone(n).(*pg_query.Node)
+
+	var err error
+	rego2sql.WalkNodes(n, func(n *pg_query.Node) {
+		if err != nil {
+			return
+		}
+		switch {
+		case n.GetTypeCast() != nil:
+			// SQLite is dynamically typed, the casts are not needed.
+			n.Node = unwrapCasts(n).Node
+		case n.GetAExpr() != nil && n.GetAExpr().Kind == pg_query.A_Expr_Kind_AEXPR_OP_ANY:
+			expr := n.GetAExpr()
+			array := unwrapCasts(expr.Rexpr).GetAArrayExpr()
+			if array == nil {
+				err = fmt.Errorf("sqlite: only ANY of an array literal is supported")
+				return
+			}
+			expr.Kind = pg_query.A_Expr_Kind_AEXPR_IN
+			expr.Rexpr = pg_query.MakeListNode(array.Elements)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func unwrapCasts(n *pg_query.Node) *pg_query.Node {
+	for n.GetTypeCast() != nil {
+		n = n.GetTypeCast().Arg
+	}
+	return n
+}
@@ -0,0 +1,125 @@
+package difftest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/difftest"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const policy = `package authz
+
+default allow := false
+
+allow if input.object.owner == input.subject.id
+
+allow if {
+	input.subject.role == "admin"
+	input.object.org in input.subject.orgs
+}
+
+allow if {
+	input.object.public
+	input.object.size < 100
+}
+`
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	matchers := rego2sql.NewVariableConverter().RegisterMatcher(
+		rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "org"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "public"}, []string{"public"}, cty.UnknownVal(cty.Bool)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "size"}, []string{"size"}, cty.UnknownVal(cty.Number)),
+	)
+
+	rows := []difftest.Row{
+		{
+			Columns: map[string]any{"owner_id": "u1", "organization_id": "o1", "public": false, "size": 10},
+			Object:  map[string]any{"owner": "u1", "org": "o1", "public": false, "size": 10},
+		},
+		{
+			Columns: map[string]any{"owner_id": "u2", "organization_id": "o1", "public": false, "size": 10},
+			Object:  map[string]any{"owner": "u2", "org": "o1", "public": false, "size": 10},
+		},
+		{
+			Columns: map[string]any{"owner_id": "u2", "organization_id": "o2", "public": true, "size": 99.5},
+			Object:  map[string]any{"owner": "u2", "org": "o2", "public": true, "size": 99.5},
+		},
+		{
+			Columns: map[string]any{"owner_id": "u2", "organization_id": "o3", "public": true, "size": 100},
+			Object:  map[string]any{"owner": "u2", "org": "o3", "public": true, "size": 100},
+		},
+	}
+
+	cfg := func(input map[string]any) difftest.Config {
+		return difftest.Config{
+			Query:   "data.authz.allow = true",
+			Modules: map[string]string{"policy.rego": policy},
+			Input:   input,
+			Convert: rego2sql.ConvertConfig{VariableConverter: matchers, TableAlias: "r"},
+			Setup: []string{
+				"CREATE TABLE resources (owner_id TEXT, organization_id TEXT, public BOOLEAN, size REAL)",
+			},
+			Table: "resources",
+			Rows:  rows,
+		}
+	}
+
+	t.Run("Member", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := difftest.Run(context.Background(), cfg(map[string]any{
+			"subject": map[string]any{"id": "u1", "role": "member", "orgs": []any{"o1"}},
+		}))
+		require.NoError(t, err)
+		require.Empty(t, res.Mismatches, res.SQL)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := difftest.Run(context.Background(), cfg(map[string]any{
+			"subject": map[string]any{"id": "u3", "role": "admin", "orgs": []any{"o1", "o3"}},
+		}))
+		require.NoError(t, err)
+		require.Equal(t, "('u3' = r.owner_id) OR (r.public AND r.size < 100) OR (r.organization_id IN ('o1', 'o3'))", res.SQL)
+		require.Empty(t, res.Mismatches, res.SQL)
+	})
+
+	t.Run("SetupRows", func(t *testing.T) {
+		t.Parallel()
+
+		// Rows inserted by Setup are returned by the SQL, but are not
+		// fixture rows.
+		c := cfg(map[string]any{"subject": map[string]any{"id": "u9"}})
+		c.Setup = append(c.Setup, "INSERT INTO resources (owner_id, organization_id, public, size) VALUES ('u9', 'o9', false, 1)")
+		res, err := difftest.Run(context.Background(), c)
+		require.NoError(t, err)
+		require.Empty(t, res.Mismatches, res.SQL)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		// The owner column is mapped to the wrong rego field.
+		c := cfg(map[string]any{"subject": map[string]any{"id": "o1"}})
+		c.Convert.VariableConverter = rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "public"}, []string{"public"}, cty.UnknownVal(cty.Bool)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "size"}, []string{"size"}, cty.UnknownVal(cty.Number)),
+		)
+
+		res, err := difftest.Run(context.Background(), c)
+		require.NoError(t, err)
+		require.Equal(t, []difftest.Mismatch{
+			{Row: 0, Allowed: false, Filtered: true},
+			{Row: 1, Allowed: false, Filtered: true},
+		}, res.Mismatches)
+		require.Equal(t, "row 0 is denied by the policy, but returned by the SQL", res.Mismatches[0].String())
+	})
+}
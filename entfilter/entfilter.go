@@ -0,0 +1,45 @@
+// Package entfilter applies a converted rego filter to ent queries.
+package entfilter
+
+import (
+	"fmt"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// Predicate converts the partial queries into a filter. It can be converted
+// to the generated predicate type of any schema:
+//
+//	client.Workspace.Query().Where(predicate.Workspace(entfilter.Predicate(cfg, partial.Queries)))
+//
+// The constants of the filter are bound as arguments, using the placeholders
+// of the dialect. Conversion errors are added to the selector, so they are
+// returned when the query is executed.
+func Predicate(cfg rego2sql.ConvertConfig, queries []ast.Body) func(*sql.Selector) {
+	node, err := rego2sql.Convert(cfg, queries)
+	if err != nil {
+		err = fmt.Errorf("convert: %w", err)
+		return func(s *sql.Selector) { s.AddError(err) }
+	}
+
+	parts, args, err := rego2sql.SplitParams(node)
+	if err != nil {
+		err = fmt.Errorf("serialize: %w", err)
+		return func(s *sql.Selector) { s.AddError(err) }
+	}
+
+	return func(s *sql.Selector) {
+		s.Where(sql.P(func(b *sql.Builder) {
+			b.WriteByte('(')
+			for i, part := range parts {
+				b.WriteString(part)
+				if i < len(args) {
+					b.Arg(args[i])
+				}
+			}
+			b.WriteByte(')')
+		}))
+	}
+}
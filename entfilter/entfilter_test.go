@@ -0,0 +1,142 @@
+package entfilter_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/entfilter"
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestPredicate(t *testing.T) {
+	t.Parallel()
+
+	cfg := rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "org_owner"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		),
+	}
+
+	testCases := []struct {
+		Name        string
+		Queries     []string
+		ExpectedIDs []string
+		ExpectError bool
+	}{
+		{
+			Name:        "Organization",
+			Queries:     []string{`"org1" = input.object.org_owner`},
+			ExpectedIDs: []string{"w1", "w2"},
+		},
+		{
+			Name: "OrganizationOrOwner",
+			Queries: []string{
+				`"org1" = input.object.org_owner`,
+				`input.object.owner = "user3"`,
+			},
+			ExpectedIDs: []string{"w1", "w2", "w4"},
+		},
+		{
+			Name:        "OrganizationAndOwner",
+			Queries:     []string{`"org1" = input.object.org_owner; input.object.owner = "user1"`},
+			ExpectedIDs: []string{"w1"},
+		},
+		{
+			Name:        "UnknownVariable",
+			Queries:     []string{`input.object.missing = "user1"`},
+			ExpectError: true,
+		},
+	}
+
+	db := setupDB(t)
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			queries := make([]ast.Body, 0, len(tc.Queries))
+			for _, q := range tc.Queries {
+				queries = append(queries, ast.MustParseBody(q))
+			}
+
+			selector := entsql.Dialect(dialect.SQLite).
+				Select("id").From(entsql.Table("workspaces")).
+				Where(entsql.EQ("deleted", false)).
+				OrderBy("id")
+			entfilter.Predicate(cfg, queries)(selector)
+			if tc.ExpectError {
+				require.Error(t, selector.Err())
+				return
+			}
+			require.NoError(t, selector.Err())
+
+			query, args := selector.Query()
+			rows, err := db.Query(query, args...)
+			require.NoError(t, err)
+			defer rows.Close()
+
+			ids := []string{}
+			for rows.Next() {
+				var id string
+				require.NoError(t, rows.Scan(&id))
+				ids = append(ids, id)
+			}
+			require.NoError(t, rows.Err())
+			require.Equal(t, tc.ExpectedIDs, ids)
+		})
+	}
+}
+
+// TestPredicatePostgres checks the parameters are numbered after the existing
+// ones.
+func TestPredicatePostgres(t *testing.T) {
+	t.Parallel()
+
+	cfg := rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		),
+	}
+
+	selector := entsql.Dialect(dialect.Postgres).
+		Select("id").From(entsql.Table("workspaces")).
+		Where(entsql.EQ("organization_id", "org1"))
+	entfilter.Predicate(cfg, []ast.Body{ast.MustParseBody(`input.object.owner = "user1"`)})(selector)
+	require.NoError(t, selector.Err())
+
+	query, args := selector.Query()
+	require.Equal(t, `SELECT "id" FROM "workspaces" WHERE "organization_id" = $1 AND ((owner_id = $2))`, query)
+	require.Equal(t, []any{"org1", "user1"}, args)
+}
+
+func setupDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	// Each connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE workspaces (
+		id TEXT PRIMARY KEY,
+		organization_id TEXT NOT NULL,
+		owner_id TEXT NOT NULL,
+		deleted BOOLEAN NOT NULL DEFAULT false
+	);
+	INSERT INTO workspaces (id, organization_id, owner_id, deleted) VALUES
+		('w1', 'org1', 'user1', false),
+		('w2', 'org1', 'user2', false),
+		('w3', 'org1', 'user3', true),
+		('w4', 'org2', 'user3', false),
+		('w5', 'org2', 'user4', false);`)
+	require.NoError(t, err)
+	return db
+}
@@ -0,0 +1,50 @@
+package rego2sql
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// UnsupportedError is returned when the rego uses a construct that cannot be
+// converted to SQL, such as an unknown builtin. Returning an error is always
+// preferred over SQL that does not match the rego.
+type UnsupportedError struct {
+	// Construct is what is not supported, such as the name of a builtin or
+	// 'with'.
+	Construct string
+	// Reason is optional, and explains why it is not supported.
+	Reason   string
+	Location *ast.Location
+}
+
+func unsupported(construct string, loc *ast.Location, reason string, args ...any) *UnsupportedError {
+	return &UnsupportedError{
+		Construct: construct,
+		Reason:    fmt.Sprintf(reason, args...),
+		Location:  loc,
+	}
+}
+
+func (e *UnsupportedError) Error() string {
+	msg := fmt.Sprintf("%s is not supported", e.Construct)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Location != nil {
+		msg = fmt.Sprintf("%s: %s", FormatLocation(e.Location), msg)
+	}
+	return msg
+}
+
+// FormatLocation formats the location as 'file:row:col', or 'row:col' if the
+// location has no file. A nil location is an empty string.
+func FormatLocation(loc *ast.Location) string {
+	if loc == nil {
+		return ""
+	}
+	if loc.File != "" {
+		return fmt.Sprintf("%s:%d:%d", loc.File, loc.Row, loc.Col)
+	}
+	return fmt.Sprintf("%d:%d", loc.Row, loc.Col)
+}
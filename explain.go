@@ -0,0 +1,234 @@
+package rego2sql
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// Explanation links the SQL of a conversion back to the rego it was converted
+// from. The SQL is OR'd from the queries, which are AND'd from their
+// expressions.
+type Explanation struct {
+	SQL     string             `json:"sql"`
+	Queries []QueryExplanation `json:"queries"`
+}
+
+type QueryExplanation struct {
+	// Query is the index of the query.
+	Query int    `json:"query"`
+	Rego  string `json:"rego"`
+	// SQL is empty if the query is not in the output. Queries merged with
+	// other queries have the SQL of the merged query.
+	SQL string `json:"sql,omitempty"`
+	// Note explains why the query is not in the output, or which queries it
+	// was merged with.
+	Note  string            `json:"note,omitempty"`
+	Exprs []ExprExplanation `json:"exprs"`
+}
+
+type ExprExplanation struct {
+	Rego     string `json:"rego"`
+	Location string `json:"location,omitempty"`
+	// SQL is empty if the expression is not in the output. Expressions on
+	// related tables have the SQL of the subquery they are in.
+	SQL string `json:"sql,omitempty"`
+	// Note explains why the expression is not in the output, or which
+	// expressions share its subquery.
+	Note string `json:"note,omitempty"`
+	// Refs are the refs of the expression, in the order they were converted.
+	Refs []RefExplanation `json:"refs"`
+}
+
+type RefExplanation struct {
+	Ref      string `json:"ref"`
+	Location string `json:"location,omitempty"`
+	Matcher  string `json:"matcher"`
+	SQL      string `json:"sql,omitempty"`
+}
+
+// Explain is like Convert, but also explains which rego each part of the SQL
+// was converted from.
+func Explain(cfg ConvertConfig, queries []ast.Body) (*pg_query.Node, *Explanation, error) {
+	explain := &Explanation{Queries: []QueryExplanation{}}
+	node, err := convert(cfg, queries, &Diagnostics{}, explain)
+	if err != nil {
+		return nil, nil, err
+	}
+	explain.SQL, err = Serialize(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, explain, nil
+}
+
+// explainSQL serializes a part of the tree, with the columns qualified as
+// they are in the output.
+func explainSQL(cfg ConvertConfig, n *pg_query.Node) (string, error) {
+	sql, err := Serialize(qualifyColumns(cfg, n))
+	if err != nil {
+		return "", fmt.Errorf("explain: %w", err)
+	}
+	return sql, nil
+}
+
+// explainMerged records the SQL of each merged query, for each of the queries
+// it is from. into is the index of the merged query of each node, and
+// explained the index of the explanation of each node.
+func explainMerged(cfg ConvertConfig, explain *Explanation, merged []*pg_query.Node, into []int, explained []int) error {
+	sqls := make([]string, len(merged))
+	for i, n := range merged {
+		var err error
+		sqls[i], err = explainSQL(cfg, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, m := range into {
+		q := &explain.Queries[explained[i]]
+		q.SQL = sqls[m]
+
+		var others []string
+		for j, other := range into {
+			if other == m && j != i {
+				others = append(others, fmt.Sprintf("%d", explain.Queries[explained[j]].Query))
+			}
+		}
+		if len(others) > 0 {
+			// Only queries of a single expression are merged.
+			for j := range q.Exprs {
+				if q.Exprs[j].SQL != "" {
+					q.Exprs[j].SQL = sqls[m]
+				}
+			}
+		}
+		switch len(others) {
+		case 0:
+		case 1:
+			q.Note = fmt.Sprintf("merged with query %s", others[0])
+		default:
+			q.Note = fmt.Sprintf("merged with queries %s", strings.Join(others, ", "))
+		}
+	}
+	return nil
+}
+
+// explainRef records the matcher of a ref of the expression being converted.
+func (c *converter) explainRef(term *ast.Term, ref ast.Ref, m VariableMatcher, item *Item) error {
+	if c.explain == nil {
+		return nil
+	}
+	expr := &c.explain.Exprs[len(c.explain.Exprs)-1]
+	for _, existing := range expr.Refs {
+		if existing.Ref == ref.String() {
+			return nil
+		}
+	}
+
+	explained := RefExplanation{
+		Ref:     ref.String(),
+		Matcher: DescribeMatcher(m),
+	}
+	if term.Location != nil {
+		explained.Location = FormatLocation(term.Location)
+	}
+	if !item.Value.HasMark(markAlwaysFalse) {
+		var err error
+		explained.SQL, err = explainSQL(c.cfg, item.Node)
+		if err != nil {
+			return err
+		}
+	}
+	expr.Refs = append(expr.Refs, explained)
+	return nil
+}
+
+// explainGroup records the SQL of an item of the query, for each of the
+// expressions it is from. explained is the index of the explanation of each
+// expression.
+func (c *converter) explainGroup(item *Item, members []int, explained []int) error {
+	if c.explain == nil {
+		return nil
+	}
+	sql, err := explainSQL(c.cfg, item.Node)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		expr := &c.explain.Exprs[explained[m]]
+		expr.SQL = sql
+		if len(members) == 1 {
+			continue
+		}
+
+		others := make([]string, 0, len(members)-1)
+		for _, other := range members {
+			if other != m {
+				others = append(others, c.explain.Exprs[explained[other]].Rego)
+			}
+		}
+		expr.Note = fmt.Sprintf("in the same subquery as: %s", strings.Join(others, "; "))
+	}
+	return nil
+}
+
+// String is the explanation as an indented tree.
+func (e *Explanation) String() string {
+	var sb strings.Builder
+	_ = e.Write(&sb)
+	return sb.String()
+}
+
+// Write writes the explanation as an indented tree.
+func (e *Explanation) Write(w io.Writer) error {
+	var err error
+	printf := func(indent int, format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, strings.Repeat("  ", indent)+format+"\n", args...)
+		}
+	}
+
+	printf(0, "%s", e.SQL)
+	for _, q := range e.Queries {
+		printf(1, "query %d: %s", q.Query, q.Rego)
+		if q.SQL != "" {
+			printf(2, "sql: %s", q.SQL)
+		}
+		if q.Note != "" {
+			printf(2, "note: %s", q.Note)
+		}
+		for _, expr := range q.Exprs {
+			if expr.Location != "" {
+				printf(2, "expr %s: %s", expr.Location, expr.Rego)
+			} else {
+				printf(2, "expr: %s", expr.Rego)
+			}
+			if expr.SQL != "" {
+				printf(3, "sql: %s", expr.SQL)
+			}
+			if expr.Note != "" {
+				printf(3, "note: %s", expr.Note)
+			}
+			for _, ref := range expr.Refs {
+				line := fmt.Sprintf("ref %s: %s", ref.Ref, ref.Matcher)
+				if ref.SQL != "" {
+					line += " -> " + ref.SQL
+				}
+				printf(3, "%s", line)
+			}
+		}
+	}
+	return err
+}
+
+// explainExpr notes why the expression being converted is not in the output.
+func (c *converter) explainExpr(note string, args ...any) {
+	if c.explain == nil {
+		return
+	}
+	c.explain.Exprs[len(c.explain.Exprs)-1].Note = fmt.Sprintf(note, args...)
+}
@@ -0,0 +1,169 @@
+package rego2sql_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	part := partialQueries(t,
+		`x := input.object.org_owner; x = "o1"; not input.object.owner = "u1"`,
+		`"read" in input.object.acl_user_list.u1`,
+	)
+	node, explain, err := rego2sql.Explain(rego2sql.ConvertConfig{
+		VariableConverter: codercfg.UserConverter(),
+		TableAlias:        "u",
+	}, part.Queries)
+	require.NoError(t, err)
+
+	sql, err := rego2sql.Serialize(node)
+	require.NoError(t, err)
+	require.Equal(t, sql, explain.SQL)
+
+	require.Equal(t, &rego2sql.Explanation{
+		SQL: "('' = 'o1' AND NOT u.id::text = 'u1')",
+		Queries: []rego2sql.QueryExplanation{
+			{
+				Query: 0,
+				Rego:  `assign(x, input.object.org_owner); x = "o1"; not input.object.owner = "u1"`,
+				SQL:   "'' = 'o1' AND NOT u.id::text = 'u1'",
+				Exprs: []rego2sql.ExprExplanation{
+					{
+						Rego:     "assign(x, input.object.org_owner)",
+						Location: "1:1",
+						Note:     "the expression binds a variable, which is replaced where it is used",
+						Refs:     []rego2sql.RefExplanation{},
+					},
+					{
+						Rego:     `x = "o1"`,
+						Location: "1:30",
+						SQL:      "'' = 'o1'",
+						Refs: []rego2sql.RefExplanation{{
+							Ref:      "input.object.org_owner",
+							Location: "1:6",
+							Matcher:  `const cty.StringVal("")`,
+							SQL:      "''",
+						}},
+					},
+					{
+						Rego:     `not input.object.owner = "u1"`,
+						Location: "1:40",
+						SQL:      "NOT u.id::text = 'u1'",
+						Refs: []rego2sql.RefExplanation{{
+							Ref:      "input.object.owner",
+							Location: "1:44",
+							Matcher:  "column id::text",
+							SQL:      "u.id::text",
+						}},
+					},
+				},
+			},
+			{
+				Query: 1,
+				Rego:  `internal.member_2("read", input.object.acl_user_list.u1)`,
+				Note:  `the query is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false`,
+				Exprs: []rego2sql.ExprExplanation{{
+					Rego:     `internal.member_2("read", input.object.acl_user_list.u1)`,
+					Location: "1:1",
+					Note:     `the expression is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false`,
+					Refs: []rego2sql.RefExplanation{{
+						Ref:      "input.object.acl_user_list.u1",
+						Location: "1:11",
+						Matcher:  "always false (acl column user_acl)",
+					}},
+				}},
+			},
+		},
+	}, explain)
+
+	require.Equal(t, `('' = 'o1' AND NOT u.id::text = 'u1')
+  query 0: assign(x, input.object.org_owner); x = "o1"; not input.object.owner = "u1"
+    sql: '' = 'o1' AND NOT u.id::text = 'u1'
+    expr 1:1: assign(x, input.object.org_owner)
+      note: the expression binds a variable, which is replaced where it is used
+    expr 1:30: x = "o1"
+      sql: '' = 'o1'
+      ref input.object.org_owner: const cty.StringVal("") -> ''
+    expr 1:40: not input.object.owner = "u1"
+      sql: NOT u.id::text = 'u1'
+      ref input.object.owner: column id::text -> u.id::text
+  query 1: internal.member_2("read", input.object.acl_user_list.u1)
+    note: the query is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false
+    expr 1:1: internal.member_2("read", input.object.acl_user_list.u1)
+      note: the expression is always false: convert call internal.member_2("read", input.object.acl_user_list.u1): arguments: term: variable "input.object.acl_user_list.u1": always false
+      ref input.object.acl_user_list.u1: always false (acl column user_acl)
+`, explain.String())
+}
+
+// TestExplainGrouped explains expressions that are grouped into a related
+// table subquery, and queries that are merged into one '?|' check. The SQL
+// of each is the SQL in the output.
+func TestExplainGrouped(t *testing.T) {
+	t.Parallel()
+
+	vc := rego2sql.NewVariableConverter().RegisterMatcher(
+		rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+		&rego2sql.RelatedTableMatcher{
+			RegoPath:   []string{"input", "object", "members"},
+			Table:      "workspace_members",
+			Alias:      "m",
+			ForeignKey: "workspace_id",
+			ParentKey:  []string{"workspaces", "id"},
+			Fields: map[string]rego2sql.RelatedColumn{
+				"user_id": {Column: "user_id", Type: cty.String},
+				"role":    {Column: "role", Type: cty.String},
+			},
+		},
+	)
+	vc.RegisterMatcher(codercfg.UserACLMatcher(vc))
+
+	part := partialQueries(t,
+		`input.object.members[i].user_id = "u1"; input.object.owner != ""; input.object.members[i].role = "admin"`,
+		`"read" in input.object.acl_user_list.u1`,
+		`"*" in input.object.acl_user_list.u1`,
+	)
+	_, explain, err := rego2sql.Explain(rego2sql.ConvertConfig{VariableConverter: vc}, part.Queries)
+	require.NoError(t, err)
+
+	require.Equal(t, `(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin') AND owner <> '') OR ((user_acl -> 'u1') ?| ARRAY['read', '*'])
+  query 0: input.object.members[i].user_id = "u1"; neq(input.object.owner, ""); input.object.members[i].role = "admin"
+    sql: EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin') AND owner <> ''
+    expr 1:1: input.object.members[i].user_id = "u1"
+      sql: EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin')
+      note: in the same subquery as: input.object.members[i].role = "admin"
+      ref input.object.members[i].user_id: related table workspace_members m -> m.user_id
+    expr 1:41: neq(input.object.owner, "")
+      sql: owner <> ''
+      ref input.object.owner: column owner -> owner
+    expr 1:67: input.object.members[i].role = "admin"
+      sql: EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin')
+      note: in the same subquery as: input.object.members[i].user_id = "u1"
+      ref input.object.members[i].role: related table workspace_members m -> m.role
+  query 1: internal.member_2("read", input.object.acl_user_list.u1)
+    sql: (user_acl -> 'u1') ?| ARRAY['read', '*']
+    note: merged with query 2
+    expr 1:1: internal.member_2("read", input.object.acl_user_list.u1)
+      sql: (user_acl -> 'u1') ?| ARRAY['read', '*']
+      ref input.object.acl_user_list.u1: acl column user_acl -> user_acl -> 'u1'
+  query 2: internal.member_2("*", input.object.acl_user_list.u1)
+    sql: (user_acl -> 'u1') ?| ARRAY['read', '*']
+    note: merged with query 1
+    expr 1:1: internal.member_2("*", input.object.acl_user_list.u1)
+      sql: (user_acl -> 'u1') ?| ARRAY['read', '*']
+      ref input.object.acl_user_list.u1: acl column user_acl -> user_acl -> 'u1'
+`, explain.String())
+
+	// The SQL of every query and expression is in the output.
+	for _, q := range explain.Queries {
+		require.Contains(t, explain.SQL, q.SQL)
+		for _, expr := range q.Exprs {
+			require.Contains(t, explain.SQL, expr.SQL)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package rego2sql_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FuzzConvert converts random rego bodies, generated from the fuzz data, with
+// the supported builtins and the shapes of refs the matchers handle. Convert
+// must never panic, and any SQL it generates must be valid.
+//
+//	go test -run '^$' -fuzz FuzzConvert
+func FuzzConvert(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 1, 2, 3, 4})
+	f.Add([]byte{1, 4, 2, 0, 7, 3, 1, 9, 5, 5})
+	f.Add([]byte{2, 8, 6, 6, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Add([]byte("input.object.acl_user_list[x]"))
+
+	numbers := rego2sql.NewVariableConverter().RegisterMatcher(
+		rego2sql.StringVarMatcher([]string{"input", "object", "count"}, []string{"count"}, cty.UnknownVal(cty.Number)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "flag"}, []string{"flag"}, cty.UnknownVal(cty.Bool)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "tags"}, []string{"tags"}, cty.UnknownVal(cty.List(cty.String))),
+		rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		rego2sql.NewJSONBPathMatcher(nil, []string{"input", "object", "labels"}, []string{"labels"}, cty.Map(cty.String)),
+		rego2sql.SessionVarMatcher([]string{"input", "subject", "id"}, "app.user_id", cty.String),
+	)
+	configs := []rego2sql.ConvertConfig{
+		{VariableConverter: codercfg.WorkspaceConverter()},
+		{VariableConverter: codercfg.TemplateConverter(), UnknownVarsFalse: true},
+		{VariableConverter: numbers, TableAlias: "t"},
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		gen := &regoGen{data: data}
+		queries := []string{gen.body()}
+		if gen.choose(2) == 0 {
+			queries = append(queries, gen.body())
+		}
+
+		bodies := make([]ast.Body, 0, len(queries))
+		for _, q := range queries {
+			body, err := ast.ParseBodyWithOpts(q, ast.ParserOptions{AllFutureKeywords: true})
+			if err != nil {
+				// The grammar is not careful to only generate valid rego.
+				t.Skip(err)
+			}
+			bodies = append(bodies, body)
+		}
+
+		for i, cfg := range configs {
+			node, err := rego2sql.Convert(cfg, bodies)
+			if err != nil {
+				continue
+			}
+
+			sql, err := rego2sql.Serialize(node)
+			if err != nil {
+				t.Fatalf("config %d: serialize %q: %v", i, queries, err)
+			}
+			if _, err := pg_query.Parse("SELECT * FROM t WHERE " + sql); err != nil {
+				t.Fatalf("config %d: parse the sql of %q: %v\n%s", i, queries, err, sql)
+			}
+			if _, _, err := rego2sql.SerializeParams(node); err != nil {
+				t.Fatalf("config %d: serialize params %q: %v", i, queries, err)
+			}
+		}
+	})
+}
+
+// regoGen generates rego from the fuzz data. Each byte picks the next
+// production of the grammar, and the zero value is used once the data runs
+// out, so any data generates a body.
+type regoGen struct {
+	data  []byte
+	depth int
+}
+
+var fuzzRefs = []string{
+	"input.object.owner",
+	"input.object.org_owner",
+	"input.object.id",
+	"input.object.count",
+	"input.object.flag",
+	"input.object.tags",
+	"input.object.tags[_]",
+	"input.object.tags[0]",
+	"input.object.labels",
+	"input.object.labels.env",
+	"input.object.labels[x]",
+	"input.object.acl_user_list",
+	"input.object.acl_user_list.me",
+	"input.object.acl_user_list[x]",
+	"input.object.acl_user_list[_][_]",
+	"input.object.acl_user_list[input.object.owner]",
+	"input.object.acl_user_list.me[0]",
+	"input.object.acl_group_list[x]",
+	"input.object.acl_group_list.all",
+	"input.object.unknown",
+	"input.object",
+	"input.subject.id",
+	"input.object.groups[_].name",
+	"x",
+	"x.name",
+}
+
+func (g *regoGen) choose(n int) int {
+	if len(g.data) == 0 {
+		return 0
+	}
+	b := g.data[0]
+	g.data = g.data[1:]
+	return int(b) % n
+}
+
+func (g *regoGen) body() string {
+	exprs := make([]string, 0, 3)
+	for i := g.choose(3); i >= 0; i-- {
+		exprs = append(exprs, g.expr())
+	}
+	return strings.Join(exprs, "; ")
+}
+
+func (g *regoGen) expr() string {
+	var expr string
+	switch g.choose(12) {
+	case 0:
+		expr = g.term() + " = " + g.term()
+	case 1:
+		expr = g.term() + " != " + g.term()
+	case 2:
+		expr = g.term() + []string{" < ", " > ", " <= ", " >= "}[g.choose(4)] + g.term()
+	case 3:
+		expr = g.term() + " in " + g.term()
+	case 4:
+		expr = fmt.Sprintf("count(%s) > %s", g.term(), g.term())
+	case 5:
+		expr = fmt.Sprintf("%s(%s, %s)", []string{"net.cidr_contains", "net.cidr_intersects"}[g.choose(2)], g.term(), g.term())
+	case 6:
+		expr = "x := " + g.term()
+	case 7:
+		expr = g.term()
+	case 8:
+		expr = fmt.Sprintf("%s = %s with input.object.owner as %s", g.term(), g.term(), g.term())
+	case 9:
+		expr = fmt.Sprintf("startswith(%s, %s)", g.term(), g.term())
+	default:
+		expr = g.ref() + " = " + g.scalar()
+	}
+	if g.choose(4) == 0 {
+		expr = "not " + expr
+	}
+	return expr
+}
+
+func (g *regoGen) term() string {
+	if g.depth > 2 {
+		return g.scalar()
+	}
+	g.depth++
+	defer func() { g.depth-- }()
+
+	switch g.choose(7) {
+	case 0, 1:
+		return g.ref()
+	case 2:
+		return g.scalar()
+	case 3:
+		return "[" + g.terms() + "]"
+	case 4:
+		return "{" + g.terms() + "}"
+	case 5:
+		return fmt.Sprintf("{%q: %s}", []string{"a", "read", "env"}[g.choose(3)], g.term())
+	default:
+		return "[]"
+	}
+}
+
+func (g *regoGen) terms() string {
+	terms := make([]string, 0, 3)
+	for i := g.choose(4); i > 0; i-- {
+		terms = append(terms, g.term())
+	}
+	return strings.Join(terms, ", ")
+}
+
+func (g *regoGen) ref() string {
+	return fuzzRefs[g.choose(len(fuzzRefs))]
+}
+
+func (g *regoGen) scalar() string {
+	return []string{
+		`"me"`, `"read"`, `""`, `"10.0.0.0/8"`, `"it's"`, "0", "1", "-2", "1.5",
+		"true", "false", "null",
+	}[g.choose(12)]
+}
@@ -0,0 +1,64 @@
+// Package gormfilter applies a converted rego filter to GORM queries.
+package gormfilter
+
+import (
+	"fmt"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scope converts the partial queries into a filter, for use with
+// (*gorm.DB).Scopes:
+//
+//	db.Scopes(gormfilter.Scope(cfg, partial.Queries)).Find(&workspaces)
+//
+// The constants of the filter are bound as arguments, using the placeholders
+// of the dialector. Conversion errors are added to the *gorm.DB, so they are
+// returned when the query is executed.
+func Scope(cfg rego2sql.ConvertConfig, queries []ast.Body) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		expr, err := Expression(cfg, queries)
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		return db.Where(expr)
+	}
+}
+
+// Expression converts the partial queries into a GORM clause expression.
+func Expression(cfg rego2sql.ConvertConfig, queries []ast.Body) (clause.Expression, error) {
+	node, err := rego2sql.Convert(cfg, queries)
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+
+	parts, args, err := rego2sql.SplitParams(node)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+	return filter{parts: parts, args: args}, nil
+}
+
+// filter is used instead of clause.Expr, as clause.Expr treats every '?' as
+// a placeholder, including the JSONB operators.
+type filter struct {
+	parts []string
+	args  []any
+}
+
+func (f filter) Build(builder clause.Builder) {
+	// The filter is wrapped, as GORM does not add parentheses around
+	// custom expressions when combining conditions.
+	_ = builder.WriteByte('(')
+	for i, part := range f.parts {
+		_, _ = builder.WriteString(part)
+		if i < len(f.args) {
+			builder.AddVar(builder, f.args[i])
+		}
+	}
+	_ = builder.WriteByte(')')
+}
@@ -0,0 +1,119 @@
+package gormfilter_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/gormfilter"
+	"github.com/glebarez/sqlite"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"gorm.io/gorm"
+)
+
+type Workspace struct {
+	ID             string
+	OrganizationID string
+	OwnerID        string
+	Deleted        bool
+}
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+
+	cfg := rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "org_owner"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		),
+	}
+
+	testCases := []struct {
+		Name        string
+		Queries     []string
+		ExpectedIDs []string
+		ExpectError bool
+	}{
+		{
+			Name:        "Organization",
+			Queries:     []string{`"org1" = input.object.org_owner`},
+			ExpectedIDs: []string{"w1", "w2"},
+		},
+		{
+			Name: "OrganizationOrOwner",
+			Queries: []string{
+				`"org1" = input.object.org_owner`,
+				`input.object.owner = "user3"`,
+			},
+			ExpectedIDs: []string{"w1", "w2", "w4"},
+		},
+		{
+			Name:        "OrganizationAndOwner",
+			Queries:     []string{`"org1" = input.object.org_owner; input.object.owner = "user1"`},
+			ExpectedIDs: []string{"w1"},
+		},
+		{
+			Name:        "Quotes",
+			Queries:     []string{`input.object.owner = "user' OR '1' = '1"`},
+			ExpectedIDs: []string{},
+		},
+		{
+			Name:        "AlwaysTrue",
+			Queries:     []string{``},
+			ExpectedIDs: []string{"w1", "w2", "w4", "w5"},
+		},
+		{
+			Name:        "UnknownVariable",
+			Queries:     []string{`input.object.missing = "user1"`},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := setupDB(t)
+			queries := make([]ast.Body, 0, len(tc.Queries))
+			for _, q := range tc.Queries {
+				queries = append(queries, ast.MustParseBody(q))
+			}
+
+			var found []Workspace
+			// The deleted condition checks the filter is not OR'd with the
+			// other conditions.
+			err := db.Scopes(gormfilter.Scope(cfg, queries)).
+				Where("deleted = ?", false).
+				Order("id").Find(&found).Error
+			if tc.ExpectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(found))
+			for _, w := range found {
+				ids = append(ids, w.ID)
+			}
+			require.Equal(t, tc.ExpectedIDs, ids)
+		})
+	}
+}
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Workspace{}))
+	require.NoError(t, db.Create([]Workspace{
+		{ID: "w1", OrganizationID: "org1", OwnerID: "user1"},
+		{ID: "w2", OrganizationID: "org1", OwnerID: "user2"},
+		{ID: "w3", OrganizationID: "org1", OwnerID: "user3", Deleted: true},
+		{ID: "w4", OrganizationID: "org2", OwnerID: "user3"},
+		{ID: "w5", OrganizationID: "org2", OwnerID: "user4"},
+	}).Error)
+	return db
+}
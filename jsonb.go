@@ -0,0 +1,125 @@
+package rego2sql
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/zclconf/go-cty/cty"
+	"google.golang.org/protobuf/proto"
+)
+
+// toJSONB returns the node of the item as a JSONB value. Items already marked
+// as JSONB are returned as is. Other values are cast to their SQL type first,
+// as to_jsonb cannot handle untyped literals.
+func toJSONB(item *Item) *pg_query.Node {
+	if IsJSONBool(item.Value) {
+		return item.Node
+	}
+
+	typ := item.Value.Type()
+	switch {
+	case typ.Equals(cty.String):
+		return funcCall("to_jsonb", typeCast(item.Node, "text"))
+	case typ.Equals(cty.Number):
+		return funcCall("to_jsonb", typeCast(item.Node, "pg_catalog", "numeric"))
+	case typ.Equals(cty.Bool):
+		return funcCall("to_jsonb", typeCast(item.Node, "pg_catalog", "bool"))
+	}
+	return funcCall("to_jsonb", item.Node)
+}
+
+// isJSONBCollection returns true if the value is a JSONB value that could be
+// an array.
+func isJSONBCollection(v cty.Value) bool {
+	if !IsJSONBool(v) {
+		return false
+	}
+	typ := v.Type()
+	return typ.IsListType() || typ.IsTupleType() || typ.IsSetType() || typ.Equals(cty.DynamicPseudoType)
+}
+
+// jsonbElementIn checks if any element of the JSONB array equals the JSONB
+// value. Postgres raises an error if the array is not an array.
+//
+//	EXISTS (SELECT 1 FROM jsonb_array_elements(array) elem(value) WHERE elem.value = value)
+func jsonbElementIn(array *pg_query.Node, value *pg_query.Node) *pg_query.Node {
+	elems := &jsonbElements{name: "elem", value: array}
+	return existsNode([]*pg_query.Node{elems.fromNode()}, []*pg_query.Node{
+		pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+			[]*pg_query.Node{pg_query.MakeStrNode("=")},
+			columnRefNode([]string{elems.name, "value"}), value, 0,
+		),
+	})
+}
+
+// mergeKeyExists merges queries that only check if the same JSONB value has a
+// key into a single query with '?|'. This is common with ACLs, where each
+// allowed action is its own query.
+//
+//	(acl -> 'me') ? 'read' OR (acl -> 'me') ? '*'
+//	 -> (acl -> 'me') ?| ARRAY['read', '*']
+//
+// The index of the merged query each query is in is returned as well.
+func mergeKeyExists(queries []*pg_query.Node) ([]*pg_query.Node, []int) {
+	merged := make([]*pg_query.Node, 0, len(queries))
+	into := make([]int, 0, len(queries))
+	// The keys of each merged query, by their index in merged.
+	keys := make(map[int][]*pg_query.Node)
+	for _, q := range queries {
+		expr := keyExistsExpr(q)
+		if expr == nil {
+			into = append(into, len(merged))
+			merged = append(merged, q)
+			continue
+		}
+
+		found := false
+		for i, existing := range merged {
+			if _, ok := keys[i]; !ok {
+				continue
+			}
+			if proto.Equal(keyExistsExpr(existing).Lexpr, expr.Lexpr) {
+				keys[i] = append(keys[i], expr.Rexpr)
+				into = append(into, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys[len(merged)] = []*pg_query.Node{expr.Rexpr}
+			into = append(into, len(merged))
+			merged = append(merged, q)
+		}
+	}
+
+	for i, k := range keys {
+		if len(k) < 2 {
+			continue
+		}
+		expr := keyExistsExpr(merged[i])
+		merged[i] = pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, []*pg_query.Node{
+			pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+				[]*pg_query.Node{pg_query.MakeStrNode("?|")},
+				expr.Lexpr, arrayNode(k), 0,
+			),
+		}, 0)
+	}
+	return merged, into
+}
+
+// keyExistsExpr returns the expression if the query is only a '?' check with
+// a constant key.
+func keyExistsExpr(query *pg_query.Node) *pg_query.A_Expr {
+	and := query.GetBoolExpr()
+	if and == nil || and.Boolop != pg_query.BoolExprType_AND_EXPR || len(and.Args) != 1 {
+		return nil
+	}
+
+	expr := and.Args[0].GetAExpr()
+	if expr == nil || expr.Kind != pg_query.A_Expr_Kind_AEXPR_OP || len(expr.Name) != 1 ||
+		expr.Name[0].GetString_().GetSval() != "?" {
+		return nil
+	}
+	if expr.Rexpr.GetAConst().GetSval() == nil {
+		return nil
+	}
+	return expr
+}
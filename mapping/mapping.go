@@ -0,0 +1,249 @@
+// Package mapping loads the variable matchers of a ConvertConfig from JSON, so
+// tools like the CLI can be configured without writing Go.
+//
+//	{
+//	  "preset": "coder.workspace",
+//	  "table_alias": "workspaces",
+//	  "matchers": [
+//	    {"kind": "column", "path": "input.object.name", "column": "name", "type": "string"},
+//	    {"kind": "session", "path": "input.subject.id", "setting": "app.user_id", "type": "string"}
+//	  ]
+//	}
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Presets are the converters that can be used as the base of a mapping.
+var Presets = map[string]func() *rego2sql.VariableConverter{
+	"coder.workspace":           codercfg.WorkspaceConverter,
+	"coder.template":            codercfg.TemplateConverter,
+	"coder.user":                codercfg.UserConverter,
+	"coder.group":               codercfg.GroupConverter,
+	"coder.organization_member": codercfg.OrganizationMemberConverter,
+}
+
+// PresetNames returns the sorted names of the presets.
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Mapping struct {
+	// Preset is the name of a converter in Presets. The matchers of the
+	// mapping are tried after the matchers of the preset.
+	Preset string `json:"preset,omitempty"`
+
+	TableAlias       string            `json:"table_alias,omitempty"`
+	Schema           string            `json:"schema,omitempty"`
+	TableAliases     map[string]string `json:"table_aliases,omitempty"`
+	UnknownVarsFalse bool              `json:"unknown_vars_false,omitempty"`
+
+	Matchers []Matcher `json:"matchers,omitempty"`
+}
+
+// Matcher describes a single rego2sql.VariableMatcher. Which fields are used
+// depends on the Kind:
+//
+//	column:  Column, Type and Cast (rego2sql.StringVarMatcher)
+//	const:   Value (rego2sql.ConstVarMatcher)
+//	session: Setting and Type (rego2sql.SessionVarMatcher)
+//	jsonb:   Column and Type (rego2sql.JSONBPathMatcher)
+//	related: Table, Alias, ForeignKey, ParentKey, ValueColumn, Type and Fields (rego2sql.RelatedTableMatcher)
+//
+// Types use the JSON encoding of cty types, such as "string" or
+// ["list", "string"]. Columns can be qualified with a table, 'w.owner_id'.
+type Matcher struct {
+	Kind string `json:"kind"`
+	// Path is the rego ref, such as 'input.object.owner'.
+	Path string `json:"path"`
+
+	Column  string          `json:"column,omitempty"`
+	Type    json.RawMessage `json:"type,omitempty"`
+	Cast    string          `json:"cast,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Setting string          `json:"setting,omitempty"`
+
+	Table       string                  `json:"table,omitempty"`
+	Alias       string                  `json:"alias,omitempty"`
+	ForeignKey  string                  `json:"foreign_key,omitempty"`
+	ParentKey   string                  `json:"parent_key,omitempty"`
+	ValueColumn string                  `json:"value_column,omitempty"`
+	Fields      map[string]RelatedField `json:"fields,omitempty"`
+
+	// AlwaysFalse makes any expression using the matched refs false.
+	AlwaysFalse bool `json:"always_false,omitempty"`
+}
+
+type RelatedField struct {
+	Column string          `json:"column"`
+	Type   json.RawMessage `json:"type"`
+}
+
+// Load reads a mapping from a JSON file.
+func Load(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func Parse(data []byte) (*Mapping, error) {
+	var m Mapping
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode mapping: %w", err)
+	}
+	return &m, nil
+}
+
+// ConvertConfig builds the config described by the mapping.
+func (m *Mapping) ConvertConfig() (rego2sql.ConvertConfig, error) {
+	converter := rego2sql.NewVariableConverter()
+	if m.Preset != "" {
+		preset, ok := Presets[m.Preset]
+		if !ok {
+			return rego2sql.ConvertConfig{}, fmt.Errorf("unknown preset %q, expected one of %s", m.Preset, strings.Join(PresetNames(), ", "))
+		}
+		converter = preset()
+	}
+
+	for i, spec := range m.Matchers {
+		matcher, err := spec.matcher(converter)
+		if err != nil {
+			return rego2sql.ConvertConfig{}, fmt.Errorf("matcher %d (%s): %w", i, spec.Path, err)
+		}
+		if spec.AlwaysFalse {
+			matcher = rego2sql.AlwaysFalseMatcher(matcher)
+		}
+		converter.RegisterMatcher(matcher)
+	}
+
+	return rego2sql.ConvertConfig{
+		VariableConverter: converter,
+		UnknownVarsFalse:  m.UnknownVarsFalse,
+		TableAlias:        m.TableAlias,
+		Schema:            m.Schema,
+		TableAliases:      m.TableAliases,
+	}, nil
+}
+
+// matcher builds the matcher. The converter is used to reference other
+// variables, such as the keys of a JSONB object.
+func (s Matcher) matcher(converter *rego2sql.VariableConverter) (rego2sql.VariableMatcher, error) {
+	if s.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	path := strings.Split(s.Path, ".")
+
+	switch s.Kind {
+	case "column":
+		if s.Column == "" {
+			return nil, fmt.Errorf("column is required")
+		}
+		typ, err := parseType(s.Type, cty.String)
+		if err != nil {
+			return nil, err
+		}
+		var matcher rego2sql.VariableMatcher = rego2sql.StringVarMatcher(path, splitColumn(s.Column), cty.UnknownVal(typ))
+		if s.Cast != "" {
+			matcher = rego2sql.CastVarMatcher(matcher, s.Cast)
+		}
+		return matcher, nil
+	case "const":
+		if len(s.Value) == 0 {
+			return nil, fmt.Errorf("value is required")
+		}
+		typ, err := ctyjson.ImpliedType(s.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+		val, err := ctyjson.Unmarshal(s.Value, typ)
+		if err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+		return rego2sql.ConstVarMatcher(path, val), nil
+	case "session":
+		if s.Setting == "" {
+			return nil, fmt.Errorf("setting is required")
+		}
+		typ, err := parseType(s.Type, cty.String)
+		if err != nil {
+			return nil, err
+		}
+		return rego2sql.SessionVarMatcher(path, s.Setting, typ), nil
+	case "jsonb":
+		if s.Column == "" {
+			return nil, fmt.Errorf("column is required")
+		}
+		typ, err := parseType(s.Type, cty.DynamicPseudoType)
+		if err != nil {
+			return nil, err
+		}
+		return rego2sql.NewJSONBPathMatcher(converter, path, splitColumn(s.Column), typ), nil
+	case "related":
+		if s.Table == "" || s.Alias == "" || s.ForeignKey == "" || s.ParentKey == "" {
+			return nil, fmt.Errorf("table, alias, foreign_key and parent_key are required")
+		}
+		typ, err := parseType(s.Type, cty.String)
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]rego2sql.RelatedColumn, len(s.Fields))
+		for name, field := range s.Fields {
+			fieldType, err := parseType(field.Type, cty.String)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", name, err)
+			}
+			fields[name] = rego2sql.RelatedColumn{Column: field.Column, Type: fieldType}
+		}
+		return &rego2sql.RelatedTableMatcher{
+			RegoPath:    path,
+			Table:       s.Table,
+			Alias:       s.Alias,
+			ForeignKey:  s.ForeignKey,
+			ParentKey:   splitColumn(s.ParentKey),
+			ValueColumn: s.ValueColumn,
+			ValueType:   typ,
+			Fields:      fields,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", s.Kind)
+}
+
+// parseType returns the default if no type is given.
+func parseType(raw json.RawMessage, def cty.Type) (cty.Type, error) {
+	if len(raw) == 0 {
+		return def, nil
+	}
+	typ, err := ctyjson.UnmarshalType(raw)
+	if err != nil {
+		return cty.NilType, fmt.Errorf("type: %w", err)
+	}
+	return typ, nil
+}
+
+func splitColumn(column string) []string {
+	return strings.Split(column, ".")
+}
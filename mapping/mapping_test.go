@@ -0,0 +1,129 @@
+package mapping_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/mapping"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name        string
+		Mapping     string
+		Queries     []string
+		ExpectedSQL string
+		ExpectError bool
+	}{
+		{
+			Name: "Column",
+			Mapping: `{"matchers": [
+				{"kind": "column", "path": "input.object.owner", "column": "owner_id", "cast": "text"},
+				{"kind": "column", "path": "input.object.level", "column": "w.level", "type": "number"}
+			]}`,
+			Queries:     []string{`input.object.owner = "u1"; input.object.level > 3`},
+			ExpectedSQL: "(owner_id::text = 'u1' AND w.level > 3)",
+		},
+		{
+			Name: "ConstAndAlwaysFalse",
+			Mapping: `{"matchers": [
+				{"kind": "const", "path": "input.object.type", "value": "workspace"},
+				{"kind": "column", "path": "input.object.org_owner", "column": "organization_id", "always_false": true}
+			]}`,
+			Queries: []string{
+				`input.object.type = "workspace"`,
+				`input.object.org_owner = "o1"`,
+			},
+			ExpectedSQL: "('workspace' = 'workspace')",
+		},
+		{
+			Name: "Session",
+			Mapping: `{"matchers": [
+				{"kind": "column", "path": "input.object.org_owner", "column": "organization_id"},
+				{"kind": "session", "path": "input.subject.orgs", "setting": "app.org_ids", "type": ["list", "string"]}
+			]}`,
+			Queries:     []string{`input.object.org_owner in input.subject.orgs`},
+			ExpectedSQL: "(organization_id = ANY(string_to_array(NULLIF(current_setting('app.org_ids', true), ''), ',')))",
+		},
+		{
+			Name: "JSONB",
+			Mapping: `{"matchers": [
+				{"kind": "jsonb", "path": "input.object.metadata", "column": "metadata", "type": ["map", "string"]}
+			]}`,
+			Queries:     []string{`input.object.metadata.team = "a"`},
+			ExpectedSQL: "((metadata ->> 'team') = 'a')",
+		},
+		{
+			Name: "Related",
+			Mapping: `{"matchers": [
+				{"kind": "related", "path": "input.object.members", "table": "workspace_members", "alias": "m",
+				 "foreign_key": "workspace_id", "parent_key": "workspaces.id", "value_column": "user_id"}
+			]}`,
+			Queries:     []string{`"u1" in input.object.members`},
+			ExpectedSQL: "(EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1'))",
+		},
+		{
+			Name: "PresetAndAlias",
+			Mapping: `{"preset": "coder.workspace", "table_alias": "w", "matchers": [
+				{"kind": "column", "path": "input.object.name", "column": "name"}
+			]}`,
+			Queries:     []string{`input.object.owner = "u1"; input.object.name = "dev"`},
+			ExpectedSQL: "(w.owner_id::text = 'u1' AND w.name = 'dev')",
+		},
+		{
+			Name:        "UnknownPreset",
+			Mapping:     `{"preset": "coder.nope"}`,
+			ExpectError: true,
+		},
+		{
+			Name:        "UnknownKind",
+			Mapping:     `{"matchers": [{"kind": "nope", "path": "input.object.owner"}]}`,
+			ExpectError: true,
+		},
+		{
+			Name:        "UnknownField",
+			Mapping:     `{"matcher": []}`,
+			ExpectError: true,
+		},
+		{
+			Name:        "InvalidType",
+			Mapping:     `{"matchers": [{"kind": "column", "path": "input.object.owner", "column": "owner_id", "type": "text"}]}`,
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := mapping.Parse([]byte(tc.Mapping))
+			if err == nil {
+				_, err = m.ConvertConfig()
+			}
+			if tc.ExpectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			cfg, err := m.ConvertConfig()
+			require.NoError(t, err)
+
+			queries := make([]ast.Body, 0, len(tc.Queries))
+			for _, q := range tc.Queries {
+				queries = append(queries, ast.MustParseBodyWithOpts(q, ast.ParserOptions{AllFutureKeywords: true}))
+			}
+			node, err := rego2sql.Convert(cfg, queries)
+			require.NoError(t, err)
+
+			sql, err := rego2sql.Serialize(node)
+			require.NoError(t, err)
+			require.Equal(t, tc.ExpectedSQL, sql)
+		})
+	}
+}
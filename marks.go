@@ -5,6 +5,8 @@ import "github.com/zclconf/go-cty/cty"
 const (
 	markJSONB      = "jsonb"
 	markUnknownRef = "unknown-ref"
+	// markAlwaysFalse is set by AlwaysFalseMatcher.
+	markAlwaysFalse = "always-false"
 )
 
 func MarkJSONB(v cty.Value) cty.Value {
@@ -0,0 +1,122 @@
+package rego2sql
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// convertNetCall converts the 'net.cidr_*' builtins to postgres network
+// address operators. All arguments are cast to 'inet' rather than 'cidr', as
+// 'cidr' rejects values with host bits set, which rego allows.
+//
+//	net.cidr_contains(a, b)   -> a::inet >>= b::inet
+//	net.cidr_intersects(a, b) -> a::inet && b::inet
+//	net.cidr_is_valid(a)      -> pg_input_is_valid(a, 'inet') AND strpos(a, '/') > 0
+//
+// Either argument can be a column or a constant, so the column can hold the
+// CIDR and the input the IP, or the other way around.
+//
+// net.cidr_is_valid of a column requires postgres 16 or later, as
+// pg_input_is_valid was added in 16. Constants are validated when converting,
+// and work with any version.
+func (c *converter) convertNetCall(call ast.Call) (*Item, error) {
+	op := call[0].String()
+	args := call[1:]
+
+	if op == "net.cidr_is_valid" {
+		termArgs, err := c.convertTerms(args, 1)
+		if err != nil {
+			return nil, fmt.Errorf("arguments: %w", err)
+		}
+		if err := requireStringArg(termArgs[0]); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if termArgs[0].Value.IsKnown() {
+			_, _, err := net.ParseCIDR(termArgs[0].Value.AsString())
+			return &Item{
+				Node:   constBoolean(err == nil, 0),
+				Value:  cty.BoolVal(err == nil),
+				Source: call.String(),
+			}, nil
+		}
+
+		// A bare address is a valid 'inet', so also require a prefix
+		// length.
+		return &Item{
+			Node: pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, []*pg_query.Node{
+				funcCall("pg_input_is_valid", termArgs[0].Node, pg_query.MakeAConstStrNode("inet", 0)),
+				pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+					[]*pg_query.Node{pg_query.MakeStrNode(">")},
+					funcCall("strpos", termArgs[0].Node, pg_query.MakeAConstStrNode("/", 0)),
+					pg_query.MakeAConstIntNode(0, 0), 0,
+				),
+			}, 0),
+			Value:  cty.UnknownVal(cty.Bool),
+			Source: call.String(),
+		}, nil
+	}
+
+	termArgs, err := c.convertTerms(args, 2)
+	if err != nil {
+		return nil, fmt.Errorf("arguments: %w", err)
+	}
+
+	for i, arg := range termArgs {
+		if err := requireStringArg(arg); err != nil {
+			return nil, fmt.Errorf("%s: argument %d: %w", op, i, err)
+		}
+	}
+
+	// Validate constants here, rather than letting the cast fail when the
+	// query is executed.
+	if err := validateNetConst(termArgs[0], false); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// The second argument of cidr_contains can be a CIDR or an IP.
+	if err := validateNetConst(termArgs[1], op == "net.cidr_contains"); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sqlOp := ">>="
+	if op == "net.cidr_intersects" {
+		sqlOp = "&&"
+	}
+
+	return &Item{
+		Node: pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+			[]*pg_query.Node{pg_query.MakeStrNode(sqlOp)},
+			typeCast(termArgs[0].Node, "inet"), typeCast(termArgs[1].Node, "inet"), 0,
+		),
+		Value:  cty.UnknownVal(cty.Bool),
+		Source: call.String(),
+	}, nil
+}
+
+func requireStringArg(arg *Item) error {
+	if !arg.Value.Type().Equals(cty.String) {
+		return fmt.Errorf("expected string, got %s", arg.Value.Type().FriendlyName())
+	}
+	return nil
+}
+
+// validateNetConst returns an error if the item is a known value that is not
+// a valid CIDR. If allowIP is true, a plain IP address is also accepted.
+func validateNetConst(arg *Item, allowIP bool) error {
+	if !arg.Value.IsKnown() {
+		return nil
+	}
+
+	s := arg.Value.AsString()
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	if allowIP && net.ParseIP(s) != nil {
+		return nil
+	}
+	return fmt.Errorf("invalid CIDR %q", s)
+}
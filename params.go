@@ -0,0 +1,216 @@
+package rego2sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+)
+
+// SerializeParams is like Serialize, but the constants are replaced with
+// positional parameters ($1, $2, ...) and returned as args. Booleans are left
+// inline.
+func SerializeParams(n *pg_query.Node) (string, []any, error) {
+	return serializeParams(n, 0)
+}
+
+// serializeParams numbers the parameters after the given offset.
+func serializeParams(n *pg_query.Node, offset int) (string, []any, error) {
+	n, args := parameterize(n, offset)
+	sql, err := Serialize(n)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, args, nil
+}
+
+// SplitParams is like SerializeParams, but returns the SQL split around each
+// parameter, so query builders can insert their own placeholders. There is
+// always one more part than args:
+//
+//	parts[0] args[0] parts[1] args[1] ... parts[n]
+func SplitParams(n *pg_query.Node) ([]string, []any, error) {
+	sql, args, err := SerializeParams(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		parts   []string
+		ordered []any
+		last    int
+	)
+	err = scanSQL(sql, func(tok sqlToken, start, end int) {
+		if tok != tokenParam {
+			return
+		}
+		// The number was parsed by scanSQL, so it is always valid.
+		i, _ := strconv.Atoi(sql[start+1 : end])
+		parts = append(parts, sql[last:start])
+		ordered = append(ordered, args[i-1])
+		last = end
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan sql: %w", err)
+	}
+	return append(parts, sql[last:]), ordered, nil
+}
+
+// parameterize returns a copy of the tree with the constants replaced by
+// parameters.
+func parameterize(n *pg_query.Node, offset int) (*pg_query.Node, []any) {
+	n = proto.Clone(n).(*pg_query.Node)
+
+	// Untyped parameters are fine for comparisons, as postgres infers the
+	// type from the other side. Function arguments and the JSONB operators
+	// are overloaded, so the type of those has to be explicit.
+	typed := make(map[*pg_query.A_Const]bool)
+	// Selected values, like the '1' of 'SELECT 1' in EXISTS, are not
+	// inputs.
+	skip := make(map[*pg_query.A_Const]bool)
+	WalkNodes(n, func(n *pg_query.Node) {
+		switch {
+		case n.GetFuncCall() != nil:
+			for _, arg := range n.GetFuncCall().Args {
+				if c := arg.GetAConst(); c != nil {
+					typed[c] = true
+				}
+			}
+		case n.GetAExpr() != nil:
+			expr := n.GetAExpr()
+			if len(expr.Name) != 1 || !jsonbOperators[expr.Name[0].GetString_().GetSval()] {
+				return
+			}
+			for _, arg := range []*pg_query.Node{expr.Lexpr, expr.Rexpr} {
+				if c := arg.GetAConst(); c != nil {
+					typed[c] = true
+				}
+			}
+		case n.GetResTarget() != nil:
+			if c := n.GetResTarget().Val.GetAConst(); c != nil {
+				skip[c] = true
+			}
+		}
+	})
+
+	var args []any
+	WalkNodes(n, func(n *pg_query.Node) {
+		c := n.GetAConst()
+		if c == nil || c.Isnull || skip[c] {
+			return
+		}
+
+		var (
+			arg      any
+			typeName []string
+		)
+		switch val := c.Val.(type) {
+		case *pg_query.A_Const_Sval:
+			arg, typeName = val.Sval.Sval, []string{"text"}
+		case *pg_query.A_Const_Ival:
+			arg, typeName = int64(val.Ival.Ival), []string{"pg_catalog", "int4"}
+		case *pg_query.A_Const_Fval:
+			f, err := strconv.ParseFloat(val.Fval.Fval, 64)
+			if err != nil {
+				return
+			}
+			arg, typeName = f, []string{"pg_catalog", "numeric"}
+		default:
+			return
+		}
+
+		args = append(args, arg)
+		param := pg_query.MakeParamRefNode(int32(offset+len(args)), 0)
+		if typed[c] {
+			param = typeCast(param, typeName...)
+		}
+		n.Node = param.Node
+	})
+	return n, args
+}
+
+var jsonbOperators = map[string]bool{
+	"->": true, "->>": true, "#>": true, "#>>": true,
+	"?": true, "?|": true, "?&": true, "@>": true, "<@": true,
+}
+
+// sqlToken is a piece of SQL found by scanSQL.
+type sqlToken int
+
+const (
+	tokenOther sqlToken = iota
+	// tokenParam is a positional parameter, such as '$1'.
+	tokenParam
+	// tokenQuestion is a '?' outside of literals and comments.
+	tokenQuestion
+	// tokenComment is a '--' or '/* */' comment.
+	tokenComment
+)
+
+// scanSQL calls fn for each parameter, '?' and comment in the SQL, skipping
+// string literals and quoted identifiers. start and end are byte offsets.
+func scanSQL(sql string, fn func(tok sqlToken, start, end int)) error {
+	for i := 0; i < len(sql); {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			// Quotes are escaped by doubling them, which this handles as
+			// two literals in a row.
+			end := strings.IndexByte(sql[i+1:], sql[i])
+			if end < 0 {
+				return fmt.Errorf("unterminated quote at %d", i)
+			}
+			i += end + 2
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			fn(tokenComment, i, i+end)
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("unterminated comment at %d", i)
+			}
+			fn(tokenComment, i, i+end+4)
+			i += end + 4
+		case sql[i] == '?':
+			fn(tokenQuestion, i, i+1)
+			i++
+		case sql[i] == '$':
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				fn(tokenParam, i, j)
+				i = j
+				continue
+			}
+
+			// Dollar quoted strings, $tag$...$tag$
+			for j < len(sql) && (isIdentChar(sql[j])) {
+				j++
+			}
+			if j < len(sql) && sql[j] == '$' && (i == 0 || !isIdentChar(sql[i-1])) {
+				tag := sql[i : j+1]
+				end := strings.Index(sql[j+1:], tag)
+				if end < 0 {
+					return fmt.Errorf("unterminated dollar quote at %d", i)
+				}
+				i = j + 1 + end + len(tag)
+				continue
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,177 @@
+package rego2sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// ErrSupportModules is returned by Partial if the policy could not be fully
+// inlined.
+var ErrSupportModules = errors.New("support modules were generated")
+
+// PartialConfig configures the partial evaluation of a policy.
+type PartialConfig struct {
+	// Query is evaluated, such as 'data.authz.allow = true'.
+	Query string
+	// Modules maps file names to rego source.
+	Modules map[string]string
+	// Input is the known input. The unknown fields are left out of the
+	// evaluation, even if they are set.
+	Input any
+	// Unknowns are the refs left unknown, such as 'input.object'. These are
+	// the refs the VariableConverter must match. It defaults to 'input'.
+	Unknowns []string
+	// RegoVersion is the version the modules are parsed with. It defaults
+	// to rego v1.
+	RegoVersion ast.RegoVersion
+}
+
+// Partial partially evaluates the query, and returns the queries to pass to
+// Convert. Policies that result in support modules, such as rules that could
+// not be inlined, are not supported. Rules with a default are only inlined if
+// the query compares them, 'data.authz.allow = true'.
+func Partial(ctx context.Context, cfg PartialConfig) ([]ast.Body, error) {
+	if cfg.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	opts := []func(*rego.Rego){
+		rego.Query(cfg.Query),
+		rego.Unknowns(cfg.Unknowns),
+	}
+	if cfg.RegoVersion != ast.RegoUndefined {
+		opts = append(opts, rego.SetRegoVersion(cfg.RegoVersion))
+	}
+	if cfg.Input != nil {
+		opts = append(opts, rego.Input(cfg.Input))
+	}
+
+	// Sorted so any errors are deterministic.
+	names := make([]string, 0, len(cfg.Modules))
+	for name := range cfg.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts = append(opts, rego.Module(name, cfg.Modules[name]))
+	}
+
+	part, err := rego.New(opts...).Partial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("partial eval: %w", err)
+	}
+	return partialQueries(part)
+}
+
+func partialQueries(part *rego.PartialQueries) ([]ast.Body, error) {
+	if len(part.Support) > 0 {
+		return nil, fmt.Errorf("partial eval: %d %w, the policy could not be fully inlined", len(part.Support), ErrSupportModules)
+	}
+	return part.Queries, nil
+}
+
+// maxPreparedQueries is the number of queries a PreparedPolicy keeps
+// prepared. Queries can come from requests, so they are not all kept.
+const maxPreparedQueries = 64
+
+// PreparedPolicy is a policy that is parsed and compiled once, for services
+// that partially evaluate queries on every request. It is safe for
+// concurrent use.
+type PreparedPolicy struct {
+	compiler    *ast.Compiler
+	regoVersion ast.RegoVersion
+
+	mu sync.Mutex
+	// queries are prepared on first use.
+	queries map[string]rego.PreparedPartialQuery
+}
+
+// PreparePolicy parses and compiles the modules, which map file names to rego
+// source. The RegoVersion defaults to rego v1.
+func PreparePolicy(modules map[string]string, regoVersion ast.RegoVersion) (*PreparedPolicy, error) {
+	if regoVersion == ast.RegoUndefined {
+		regoVersion = ast.DefaultRegoVersion
+	}
+
+	// Sorted so any errors are deterministic.
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parsed := make(map[string]*ast.Module, len(modules))
+	for _, name := range names {
+		module, err := ast.ParseModuleWithOpts(name, modules[name], ast.ParserOptions{RegoVersion: regoVersion})
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = module
+	}
+
+	compiler := ast.NewCompiler().WithDefaultRegoVersion(regoVersion)
+	if compiler.Compile(parsed); compiler.Failed() {
+		return nil, compiler.Errors
+	}
+	return &PreparedPolicy{
+		compiler:    compiler,
+		regoVersion: regoVersion,
+		queries:     make(map[string]rego.PreparedPartialQuery),
+	}, nil
+}
+
+// Partial is like the Partial function, with the modules of the policy. The
+// unknowns default to 'input'.
+func (p *PreparedPolicy) Partial(ctx context.Context, query string, input any, unknowns []string) ([]ast.Body, error) {
+	if query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	pq, err := p.prepare(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("partial eval: %w", err)
+	}
+
+	if len(unknowns) == 0 {
+		unknowns = []string{"input"}
+	}
+	opts := []rego.EvalOption{rego.EvalUnknowns(unknowns)}
+	if input != nil {
+		opts = append(opts, rego.EvalInput(input))
+	}
+	part, err := pq.Partial(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("partial eval: %w", err)
+	}
+	return partialQueries(part)
+}
+
+func (p *PreparedPolicy) prepare(ctx context.Context, query string) (rego.PreparedPartialQuery, error) {
+	p.mu.Lock()
+	pq, ok := p.queries[query]
+	p.mu.Unlock()
+	if ok {
+		return pq, nil
+	}
+
+	pq, err := rego.New(
+		rego.Query(query),
+		rego.Compiler(p.compiler),
+		rego.SetRegoVersion(p.regoVersion),
+	).PrepareForPartial(ctx)
+	if err != nil {
+		return rego.PreparedPartialQuery{}, err
+	}
+
+	p.mu.Lock()
+	if len(p.queries) < maxPreparedQueries {
+		p.queries[query] = pq
+	}
+	p.mu.Unlock()
+	return pq, nil
+}
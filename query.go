@@ -1,6 +1,7 @@
 package rego2sql
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/open-policy-agent/opa/v1/ast"
@@ -8,52 +9,93 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
-const (
-	stopVisiting     = true
-	continueVisiting = false
+var (
+	// errUnknownVariable is returned when no VariableMatcher can convert a ref.
+	errUnknownVariable = errors.New("unknown variable")
+	// errAlwaysFalse is returned when a query uses a variable from an
+	// AlwaysFalseMatcher.
+	errAlwaysFalse = errors.New("always false")
 )
 
 type converter struct {
+	cfg   ConvertConfig
 	stack *stack[*Item]
+
+	// relations are the related tables referenced by the expression
+	// currently being converted.
+	relations []relationMark
+	// withs are the 'with' modifiers of the expression currently being
+	// converted.
+	withs []*ast.With
+	// bindings are the local variables of the query, see bindVariables.
+	bindings map[ast.Var]*ast.Term
+	// resolving are the variables currently being resolved, to detect
+	// cycles.
+	resolving map[ast.Var]bool
+
+	// diags collects the diagnostics of all queries. query is the index of
+	// the query being converted.
+	diags *Diagnostics
+	query int
+	// explain is the explanation of the query, if one is being built.
+	explain *QueryExplanation
 }
 
-func (c *converter) convertQuery(cfg ConvertConfig, q ast.Body) (*pg_query.Node, error) {
-	var visitErr error
-
-	ast.NewGenericVisitor(func(n interface{}) bool {
-		switch val := n.(type) {
-		case *ast.Expr:
-			if val.IsCall() {
-				node, err := convertCall(cfg, val.Terms.([]*ast.Term))
-				if err != nil {
-					visitErr = fmt.Errorf("convert call %s: %w", val.String(), err)
-					return stopVisiting
-				}
-				c.stack.Push(node)
-				return stopVisiting
+func (c *converter) convertQuery(q ast.Body) (*pg_query.Node, error) {
+	bindingExprs := c.bindVariables(q)
+
+	exprs := make([]convertedExpr, 0, len(q))
+	// explained is the index of the explanation of each converted
+	// expression.
+	explained := make([]int, 0, len(q))
+	for i, expr := range q {
+		if c.explain != nil {
+			explained := ExprExplanation{Rego: expr.String(), Refs: []RefExplanation{}}
+			if expr.Location != nil {
+				explained.Location = FormatLocation(expr.Location)
 			}
+			c.explain.Exprs = append(c.explain.Exprs, explained)
+		}
+		if bindingExprs[i] {
+			// The binding is substituted wherever the variable is used.
+			c.explainExpr("the expression binds a variable, which is replaced where it is used")
+			continue
+		}
 
-			// TODO: Check other types of expressions
-			return continueVisiting
-		case ast.Body:
-		case ast.Call:
-			return stopVisiting
-		case *ast.Term:
-			node, err := convertTerm(cfg, val)
-			if err != nil {
-				visitErr = fmt.Errorf("convert term %s: %w", val.String(), err)
-				return stopVisiting
+		c.relations = nil
+		item, err := c.convertExpr(expr)
+		if err != nil {
+			if c.isFalse(err) {
+				c.explainExpr("the expression is always false: %s", err)
 			}
-			c.stack.Push(node)
-			return stopVisiting
-		default:
-			visitErr = fmt.Errorf("unsupported type %T", n)
-			return stopVisiting
+			return nil, err
+		}
+		if item == nil {
+			// The expression is always true.
+			c.explainExpr("the expression is always true")
+			continue
+		}
+		exprs = append(exprs, convertedExpr{item: item, relations: c.relations})
+		if c.explain != nil {
+			explained = append(explained, len(c.explain.Exprs)-1)
 		}
-		return continueVisiting
-	}).Walk(q)
-	if visitErr != nil {
-		return nil, visitErr
+	}
+
+	// Expressions on related tables are moved into subqueries.
+	items, from, err := groupRelations(exprs)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		c.stack.Push(item)
+		if err := c.explainGroup(item, from[i], explained); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q) > 0 && c.stack.Len() == 0 {
+		// Every expression was true.
+		return pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, []*pg_query.Node{constBoolean(true, 0)}, 0), nil
 	}
 
 	// Join all nodes with AND
@@ -73,8 +115,202 @@ func (c *converter) convertQuery(cfg ConvertConfig, q ast.Body) (*pg_query.Node,
 	return pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, nodes, 0), nil
 }
 
+// bindVariables finds the expressions that bind a local variable, such as
+// 'x := input.object.owner' or '__local0__ = input.object.org_owner'. Partial
+// evaluation leaves these when it cannot inline the variable. The bound term
+// is substituted wherever the variable is used, so the binding expressions
+// themselves are skipped. The indexes of those expressions are returned.
+func (c *converter) bindVariables(q ast.Body) map[int]bool {
+	c.bindings = make(map[ast.Var]*ast.Term)
+	c.resolving = make(map[ast.Var]bool)
+
+	bindingExprs := make(map[int]bool)
+	for i, expr := range q {
+		if expr.Negated || len(expr.With) > 0 || !(expr.IsEquality() || expr.IsAssignment()) {
+			continue
+		}
+
+		terms := expr.Terms.([]*ast.Term)
+		if len(terms) != 3 {
+			continue
+		}
+
+		for _, pair := range [][2]*ast.Term{{terms[1], terms[2]}, {terms[2], terms[1]}} {
+			v, ok := pair[0].Value.(ast.Var)
+			if !ok || v.IsWildcard() {
+				continue
+			}
+			if _, bound := c.bindings[v]; bound {
+				// A bound variable is being compared.
+				continue
+			}
+			if pair[1].Vars().Contains(v) {
+				continue
+			}
+
+			c.bindings[v] = pair[1]
+			bindingExprs[i] = true
+			break
+		}
+	}
+	return bindingExprs
+}
+
+// resolveVar returns the term bound to the variable, or nil if it is unbound.
+func (c *converter) resolveVar(v ast.Var) (*ast.Term, error) {
+	term, ok := c.bindings[v]
+	if !ok {
+		return nil, nil
+	}
+	if c.resolving[v] {
+		return nil, fmt.Errorf("variable %s is bound to itself", v)
+	}
+	return term, nil
+}
+
+// convertBound converts the term bound to a variable.
+func (c *converter) convertBound(v ast.Var, term *ast.Term) (*Item, error) {
+	c.resolving[v] = true
+	defer delete(c.resolving, v)
+	return c.convertTerm(term)
+}
+
+// plugRef substitutes the bound variables of the ref. If the head of the ref
+// is bound to another ref, the two are joined:
+//
+//	g := input.object.groups[i]; g.name -> input.object.groups[i].name
+func (c *converter) plugRef(ref ast.Ref) (ast.Ref, error) {
+	if head, ok := ref[0].Value.(ast.Var); ok {
+		bound, err := c.resolveVar(head)
+		if err != nil {
+			return nil, err
+		}
+		if bound != nil {
+			if boundRef, ok := bound.Value.(ast.Ref); ok {
+				c.resolving[head] = true
+				defer delete(c.resolving, head)
+				return c.plugRef(boundRef.Concat(ref[1:]))
+			}
+		}
+	}
+
+	plugged := ref
+	for i := 1; i < len(ref); i++ {
+		v, ok := ref[i].Value.(ast.Var)
+		if !ok {
+			continue
+		}
+		bound, err := c.resolveVar(v)
+		if err != nil {
+			return nil, err
+		}
+		if bound == nil {
+			continue
+		}
+		if plugged.Equal(ref) {
+			plugged = ref.Copy()
+		}
+		plugged[i] = bound
+	}
+	return plugged, nil
+}
+
+// convertExpr converts a single expression of a query body. A nil item is
+// returned if the expression is always true.
+func (c *converter) convertExpr(expr *ast.Expr) (*Item, error) {
+	for _, w := range expr.With {
+		target, ok := w.Target.Value.(ast.Ref)
+		// Only overrides of the input can be applied to the matchers. Data
+		// and function mocks change how the policy itself is evaluated.
+		if !ok || !target.HasPrefix(ast.InputRootRef) {
+			return nil, unsupported("with", w.Location, "only 'input' can be replaced, found %s", w.Target)
+		}
+	}
+	c.withs = expr.With
+	defer func() { c.withs = nil }()
+
+	item, err := c.convertExprTerms(expr)
+	if !expr.Negated {
+		if err == nil && isConstFalse(item) {
+			// The query can never be true, so it is dropped instead of
+			// adding 'false' to it.
+			return nil, fmt.Errorf("expression %q: %w", expr.String(), errAlwaysFalse)
+		}
+		return item, err
+	}
+
+	if err != nil {
+		// Not false is true. A variable that cannot be converted is not
+		// false, so the error is returned to drop the whole query with
+		// UnknownVarsFalse, rather than to match every row.
+		if errors.Is(err, errAlwaysFalse) {
+			c.diags.drop(c.query, expr.String(), "the negated expression is always false: %s", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if isConstFalse(item) {
+		c.diags.drop(c.query, expr.String(), "the negated expression is always false")
+		return nil, nil
+	}
+
+	if len(c.relations) > 0 {
+		// The subquery has to be negated as a whole, the rows of the related
+		// table cannot be shared with other expressions.
+		items, _, err := groupRelations([]convertedExpr{{item: item, relations: c.relations}})
+		if err != nil {
+			return nil, err
+		}
+		c.relations = nil
+		item = items[0]
+	}
+
+	if item.Value.Type() != cty.Bool {
+		return nil, fmt.Errorf("expected boolean type, got %s for rego %q", item.Value, item.Source)
+	}
+	return &Item{
+		Node:   pg_query.MakeBoolExprNode(pg_query.BoolExprType_NOT_EXPR, []*pg_query.Node{item.Node}, 0),
+		Value:  cty.UnknownVal(cty.Bool),
+		Source: expr.String(),
+	}, nil
+}
+
+// isConstFalse returns true if the item is the constant false, such as
+// membership in an empty collection.
+func isConstFalse(item *Item) bool {
+	v := item.Value
+	return !v.IsMarked() && v.IsKnown() && !v.IsNull() && v.Type().Equals(cty.Bool) && v.False()
+}
+
+// isFalse returns true if the error means the expression is false, rather
+// than that it could not be converted.
+func (c *converter) isFalse(err error) bool {
+	return errors.Is(err, errAlwaysFalse) ||
+		(c.cfg.UnknownVarsFalse && errors.Is(err, errUnknownVariable))
+}
+
+func (c *converter) convertExprTerms(expr *ast.Expr) (*Item, error) {
+	switch terms := expr.Terms.(type) {
+	case []*ast.Term:
+		node, err := c.convertCall(terms)
+		if err != nil {
+			return nil, fmt.Errorf("convert call %s: %w", expr.String(), err)
+		}
+		return node, nil
+	case *ast.Term:
+		node, err := c.convertTerm(terms)
+		if err != nil {
+			return nil, fmt.Errorf("convert term %s: %w", terms.String(), err)
+		}
+		return node, nil
+	default:
+		return nil, unsupported(ast.TypeName(expr.Terms), expr.Location, "")
+	}
+}
+
 // convertCall converts a function call to a SQL expression.
-func convertCall(cfg ConvertConfig, call ast.Call) (*Item, error) {
+func (c *converter) convertCall(call ast.Call) (*Item, error) {
 	if len(call) == 0 {
 		return nil, fmt.Errorf("empty call")
 	}
@@ -86,25 +322,59 @@ func convertCall(cfg ConvertConfig, call ast.Call) (*Item, error) {
 		args = call[1:]
 	}
 
+	if ref, ok := op.Value.(ast.Ref); ok && ref.HasPrefix(ast.DefaultRootRef) {
+		return nil, unsupported("user-defined function", op.Location,
+			"%s should be inlined by partial evaluation", ref)
+	}
+
 	opString := op.String()
 	// Supported operators.
 	switch op.String() {
 	case "neq", "eq", "equals", "equal":
-		termArgs, err := convertTerms(cfg, args, 2)
+		termArgs, err := c.convertTerms(args, 2)
 		if err != nil {
 			return nil, fmt.Errorf("arguments: %w", err)
 		}
 
-		if !termArgs[0].Value.Type().Equals(termArgs[1].Value.Type()) {
+		sqlOp := "="
+		if opString == "neq" || opString == "notequals" || opString == "notequal" {
+			sqlOp = "<>"
+		}
+
+		left, right := termArgs[0].Node, termArgs[1].Node
+		if IsJSONBool(termArgs[0].Value) || IsJSONBool(termArgs[1].Value) {
+			// JSONB can hold any type, so compare both sides as JSONB.
+			left, right = toJSONB(termArgs[0]), toJSONB(termArgs[1])
+		} else if !termArgs[0].Value.Type().Equals(termArgs[1].Value.Type()) {
 			return nil, fmt.Errorf("arguments are not the same type for equality: %q",
 				call.String())
 		}
 
-		sqlOp := "="
-		if opString == "neq" || opString == "notequals" || opString == "notequal" {
-			sqlOp = "<>"
+		return &Item{
+			Node: pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+				[]*pg_query.Node{pg_query.MakeStrNode(sqlOp)},
+				left, right, 0,
+			),
+			Value:  cty.UnknownVal(cty.Bool),
+			Source: call.String(),
+		}, nil
+	case "lt", "gt", "lte", "gte":
+		termArgs, err := c.convertTerms(args, 2)
+		if err != nil {
+			return nil, fmt.Errorf("arguments: %w", err)
 		}
 
+		typ := termArgs[0].Value.Type()
+		if !typ.Equals(termArgs[1].Value.Type()) {
+			return nil, fmt.Errorf("arguments are not the same type for comparison: %q",
+				call.String())
+		}
+		if !typ.Equals(cty.Number) && !typ.Equals(cty.String) {
+			return nil, fmt.Errorf("comparison of %s is not supported: %q",
+				typ.FriendlyName(), call.String())
+		}
+
+		sqlOp := map[string]string{"lt": "<", "gt": ">", "lte": "<=", "gte": ">="}[opString]
 		return &Item{
 			Node: pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
 				[]*pg_query.Node{pg_query.MakeStrNode(sqlOp)},
@@ -114,12 +384,60 @@ func convertCall(cfg ConvertConfig, call ast.Call) (*Item, error) {
 			Source: call.String(),
 		}, nil
 	case "internal.member_2":
-		termArgs, err := convertTerms(cfg, args, 2)
+		termArgs, err := c.convertTerms(args, 2)
 		if err != nil {
 			return nil, fmt.Errorf("arguments: %w", err)
 		}
 
-		if termArgs[1].Value.Type().IsListType() {
+		if rel, ok := collectionRelation(termArgs[1].Value); ok {
+			// Membership in a related table is checked on each of its rows,
+			// see RelatedTableMatcher.
+			if !termArgs[0].Value.Type().Equals(rel.source.(*RelatedTableMatcher).ValueType) {
+				return nil, fmt.Errorf("member_2: %s is not the same type as the elements of %s", termArgs[0].Source, termArgs[1].Source)
+			}
+			return &Item{
+				Node: pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+					[]*pg_query.Node{pg_query.MakeStrNode("=")},
+					termArgs[1].Node, termArgs[0].Node, 0,
+				),
+				Value:  cty.UnknownVal(cty.Bool),
+				Source: call.String(),
+			}, nil
+		}
+
+		if coll := termArgs[1].Value; !coll.IsMarked() && coll.IsKnown() && !coll.IsNull() && coll.CanIterateElements() && coll.LengthInt() == 0 {
+			// Nothing is a member of an empty collection.
+			return &Item{
+				Node:   constBoolean(false, 0),
+				Value:  cty.BoolVal(false),
+				Source: call.String(),
+			}, nil
+		}
+
+		if isJSONBCollection(termArgs[1].Value) && !termArgs[0].Value.Type().Equals(cty.String) {
+			if isJSONBPrimitive(termArgs[0].Value.Type()) {
+				// '@>' of a number or bool is an equality check on the
+				// elements, and can use an index.
+				return &Item{
+					Node: pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+						[]*pg_query.Node{pg_query.MakeStrNode("@>")},
+						termArgs[1].Node, funcCall("jsonb_build_array", termArgs[0].Node), 0,
+					),
+					Value:  cty.UnknownVal(cty.Bool),
+					Source: call.String(),
+				}, nil
+			}
+
+			// '@>' is a partial match for objects and arrays, so {"a": 1}
+			// would be in [{"a": 1, "b": 2}]. Compare each element instead.
+			return &Item{
+				Node:   jsonbElementIn(termArgs[1].Node, toJSONB(termArgs[0])),
+				Value:  cty.UnknownVal(cty.Bool),
+				Source: call.String(),
+			}, nil
+		}
+
+		if termArgs[1].Value.Type().IsListType() || isJSONBCollection(termArgs[1].Value) {
 			// TODO: Probably handle more json types better. This is hard coded
 			// for how we do it in coder.
 			if IsJSONBool(termArgs[1].Value) {
@@ -145,16 +463,40 @@ func convertCall(cfg ConvertConfig, call ast.Call) (*Item, error) {
 		}
 
 		return nil, fmt.Errorf("member_2: second argument is not a list: %q", call.String())
+	case "count":
+		termArgs, err := c.convertTerms(args, 1)
+		if err != nil {
+			return nil, fmt.Errorf("arguments: %w", err)
+		}
+
+		node, err := countNode(termArgs[0])
+		if err != nil {
+			return nil, fmt.Errorf("count: %w", err)
+		}
+		return &Item{
+			Node:   node,
+			Value:  cty.UnknownVal(cty.Number),
+			Source: call.String(),
+		}, nil
+	case "net.cidr_contains", "net.cidr_intersects", "net.cidr_is_valid":
+		return c.convertNetCall(call)
 	default:
-		return nil, fmt.Errorf("operator %s not supported", op)
+		return nil, unsupported(opString, op.Location, "")
 	}
 }
 
-func convertTerm(cfg ConvertConfig, term *ast.Term) (*Item, error) {
+func (c *converter) convertTerm(term *ast.Term) (*Item, error) {
 	source := term.String()
 	switch val := term.Value.(type) {
 	case ast.Var:
-		return nil, fmt.Errorf("var not yet supported")
+		bound, err := c.resolveVar(val)
+		if err != nil {
+			return nil, err
+		}
+		if bound == nil {
+			return nil, unsupported("var", term.Location, "%s is not bound to a value", val)
+		}
+		return c.convertBound(val, bound)
 	case ast.Ref:
 		if len(val) == 0 {
 			// A reference with no text is a variable with no name?
@@ -162,7 +504,29 @@ func convertTerm(cfg ConvertConfig, term *ast.Term) (*Item, error) {
 			return nil, fmt.Errorf("empty ref not supported")
 		}
 
-		if cfg.VariableConverter == nil {
+		val, err := c.plugRef(val)
+		if err != nil {
+			return nil, err
+		}
+		if len(val) == 1 {
+			// Only the variable is left.
+			return c.convertTerm(ast.NewTerm(val[0].Value))
+		}
+
+		replaced, err := c.applyWith(val)
+		if err != nil {
+			return nil, err
+		}
+		if replaced != nil {
+			// The value of a 'with' is not affected by the other 'with'
+			// modifiers.
+			withs := c.withs
+			c.withs = nil
+			defer func() { c.withs = withs }()
+			return c.convertTerm(replaced)
+		}
+
+		if c.cfg.VariableConverter == nil {
 			return nil, fmt.Errorf("variable converter not set, ref %q cannot be handled", val.String())
 		}
 
@@ -173,10 +537,18 @@ func convertTerm(cfg ConvertConfig, term *ast.Term) (*Item, error) {
 		//	- regoAst.Var if the field reference is a variable itself. Such as
 		//    the wildcard "[_]"
 		// 3. Repeat 1-2 until the end of the reference.
-		node, ok := cfg.VariableConverter.ConvertVariable(val)
+		matcher, node, ok := matchVariable(c.cfg.VariableConverter, val)
 		if !ok {
-			return nil, fmt.Errorf("variable %q cannot be converted", val.String())
+			return nil, fmt.Errorf("variable %q cannot be converted: %w", val.String(), errUnknownVariable)
 		}
+		c.diags.match(c.query, val, matcher)
+		if err := c.explainRef(term, val, matcher, node); err != nil {
+			return nil, err
+		}
+		if node.Value.HasMark(markAlwaysFalse) {
+			return nil, fmt.Errorf("variable %q: %w", val.String(), errAlwaysFalse)
+		}
+		c.relations = append(c.relations, relationMarks(node.Value)...)
 		return node, nil
 	case ast.String:
 		return &Item{
@@ -210,64 +582,143 @@ func convertTerm(cfg ConvertConfig, term *ast.Term) (*Item, error) {
 			Source: val.String(),
 		}, nil
 	case *ast.Array:
+		if val.Len() == 0 {
+			// The element type is unknown, so default to text.
+			return &Item{
+				Node:   arrayTypeCast(arrayNode(nil), "text"),
+				Value:  cty.ListValEmpty(cty.DynamicPseudoType),
+				Source: val.String(),
+			}, nil
+		}
+
 		arrayType := cty.NilType
+		// Arrays of mixed types, or of JSONB values, have to be built as a
+		// JSONB array instead.
+		asJSONB := false
 
 		ctyList := make([]cty.Value, 0, val.Len())
 		elemNodes := make([]*pg_query.Node, 0, val.Len())
-		elems := make([]*Item, 0, val.Len())
 		for i := 0; i < val.Len(); i++ {
-			value, err := convertTerm(cfg, val.Elem(i))
+			value, err := c.convertTerm(val.Elem(i))
 			if err != nil {
 				return nil, fmt.Errorf("array element %d in %q: %w", i, val.String(), err)
 			}
 			if i == 0 {
 				arrayType = value.Value.Type()
-			} else {
-				if !value.Value.Type().Equals(arrayType) {
-					return nil, fmt.Errorf("array of mixed types, this is unsupported: %q", val.String())
-				}
+			} else if !value.Value.Type().Equals(arrayType) {
+				asJSONB = true
+			}
+			if IsJSONBool(value.Value) {
+				asJSONB = true
 			}
-			elems = append(elems, value)
+			unmarked, _ := value.Value.UnmarkDeep()
 			elemNodes = append(elemNodes, value.Node)
-			ctyList = append(ctyList, value.Value)
+			ctyList = append(ctyList, unmarked)
+		}
+
+		if asJSONB {
+			return &Item{
+				Node:   funcCall("jsonb_build_array", elemNodes...),
+				Value:  MarkJSONB(cty.TupleVal(ctyList)),
+				Source: val.String(),
+			}, nil
 		}
 
 		return &Item{
-			Node: &pg_query.Node{
-				Node: &pg_query.Node_AArrayExpr{
-					AArrayExpr: &pg_query.A_ArrayExpr{
-						Elements: elemNodes,
-						Location: 0,
-					},
-				},
-			},
+			Node:   arrayNode(elemNodes),
 			Value:  cty.ListVal(ctyList),
 			Source: val.String(),
 		}, nil
 	case ast.Object:
-		return nil, fmt.Errorf("object not yet supported")
+		// Objects are built as JSONB, as postgres has no other type for
+		// arbitrary key/value pairs.
+		args := make([]*pg_query.Node, 0, val.Len()*2)
+		attrs := make(map[string]cty.Value, val.Len())
+		for _, key := range val.Keys() {
+			keyStr, ok := key.Value.(ast.String)
+			if !ok {
+				return nil, fmt.Errorf("object key %s in %q: only string keys are supported", key.String(), val.String())
+			}
+
+			value, err := c.convertTerm(val.Get(key))
+			if err != nil {
+				return nil, fmt.Errorf("object key %s in %q: %w", key.String(), val.String(), err)
+			}
+
+			args = append(args, pg_query.MakeAConstStrNode(string(keyStr), 0), value.Node)
+			attrs[string(keyStr)], _ = value.Value.UnmarkDeep()
+		}
+
+		return &Item{
+			Node:   funcCall("jsonb_build_object", args...),
+			Value:  MarkJSONB(cty.ObjectVal(attrs)),
+			Source: val.String(),
+		}, nil
 	case ast.Set:
 		// Just treat a set like an array for now.
 		arr := val.Sorted()
-		return convertTerm(cfg, &ast.Term{
+		return c.convertTerm(&ast.Term{
 			Value:    arr,
 			Location: term.Location,
 		})
 	case ast.Call:
-		return convertCall(cfg, val)
+		return c.convertCall(val)
 	default:
-		return nil, fmt.Errorf("%T not yet supported", val)
+		return nil, unsupported(ast.TypeName(val), term.Location, "")
+	}
+}
+
+// applyWith returns the term that replaces the ref, if the ref is overridden
+// by a 'with' modifier of the current expression. Nil is returned if it is
+// not overridden.
+func (c *converter) applyWith(ref ast.Ref) (*ast.Term, error) {
+	// The last 'with' takes precedence.
+	for i := len(c.withs) - 1; i >= 0; i-- {
+		w := c.withs[i]
+		target := w.Target.Value.(ast.Ref)
+
+		if !ref.HasPrefix(target) {
+			if target.HasPrefix(ref) {
+				return nil, unsupported("with", w.Location,
+					"%s is partially replaced by %s", ref, w.Target)
+			}
+			continue
+		}
+
+		rest := ref[len(target):]
+		if len(rest) == 0 {
+			return w.Value, nil
+		}
+
+		switch value := w.Value.Value.(type) {
+		case ast.Ref:
+			return ast.NewTerm(value.Concat(rest)), nil
+		case ast.Object, *ast.Array:
+			if !rest.IsGround() {
+				return nil, unsupported("with", w.Location,
+					"%s cannot be looked up in %s", rest, w.Value)
+			}
+			found, err := value.Find(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s not found in %s: %w", ref, w.Value, err)
+			}
+			return ast.NewTerm(found), nil
+		default:
+			return nil, unsupported("with", w.Location,
+				"%s cannot be looked up in %s", rest, w.Value)
+		}
 	}
+	return nil, nil
 }
 
-func convertTerms(cfg ConvertConfig, terms []*ast.Term, expected int) ([]*Item, error) {
+func (c *converter) convertTerms(terms []*ast.Term, expected int) ([]*Item, error) {
 	if len(terms) != expected {
 		return nil, fmt.Errorf("expected %d terms, got %d", expected, len(terms))
 	}
 
 	result := make([]*Item, 0, len(terms))
 	for _, t := range terms {
-		term, err := convertTerm(cfg, t)
+		term, err := c.convertTerm(t)
 		if err != nil {
 			return nil, fmt.Errorf("term: %w", err)
 		}
@@ -276,3 +727,27 @@ func convertTerms(cfg ConvertConfig, terms []*ast.Term, expected int) ([]*Item,
 
 	return result, nil
 }
+
+// countNode returns the number of elements of a collection, or the length of a
+// string.
+func countNode(arg *Item) (*pg_query.Node, error) {
+	typ := arg.Value.Type()
+	if IsJSONBool(arg.Value) {
+		if typ.IsListType() || typ.IsTupleType() || typ.IsSetType() {
+			return funcCall("jsonb_array_length", arg.Node), nil
+		}
+		return nil, fmt.Errorf("JSONB of type %s is not supported", typ.FriendlyName())
+	}
+
+	if _, ok := collectionRelation(arg.Value); ok {
+		return nil, fmt.Errorf("related tables are not supported")
+	}
+
+	switch {
+	case typ.IsListType():
+		return funcCall("cardinality", arg.Node), nil
+	case typ.Equals(cty.String):
+		return funcCall("char_length", arg.Node), nil
+	}
+	return nil, fmt.Errorf("%s is not supported", typ.FriendlyName())
+}
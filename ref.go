@@ -2,6 +2,8 @@ package rego2sql
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/open-policy-agent/opa/v1/ast"
 	pg_query "github.com/pganalyze/pg_query_go/v6"
@@ -12,6 +14,26 @@ type VariableMatcher interface {
 	ConvertVariable(rego ast.Ref) (*Item, bool)
 }
 
+// PathMatcher is a VariableMatcher that only matches refs starting with its
+// rego path. A Converter uses the path to index its matchers, so only the
+// matchers of a ref's path are tried. Matchers that do not implement it are
+// tried for every ref.
+type PathMatcher interface {
+	VariableMatcher
+	// MatchPath returns the rego path, such as ["input", "object", "owner"],
+	// or nil if any ref can be matched.
+	MatchPath() []string
+}
+
+// matchPath returns the rego path of the matcher, or nil if it can match any
+// ref.
+func matchPath(m VariableMatcher) []string {
+	if pm, ok := m.(PathMatcher); ok {
+		return pm.MatchPath()
+	}
+	return nil
+}
+
 type VariableConverter struct {
 	converters []VariableMatcher
 }
@@ -27,12 +49,50 @@ func (vc *VariableConverter) RegisterMatcher(m ...VariableMatcher) *VariableConv
 }
 
 func (vc *VariableConverter) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	_, n, ok := vc.MatchVariable(rego)
+	return n, ok
+}
+
+// MatchVariable is like ConvertVariable, but also returns the matcher that
+// converted the ref. Nested VariableConverters are searched for the matcher.
+func (vc *VariableConverter) MatchVariable(rego ast.Ref) (VariableMatcher, *Item, bool) {
 	for _, c := range vc.converters {
+		if nested, ok := c.(*VariableConverter); ok {
+			if m, n, ok := nested.MatchVariable(rego); ok {
+				return m, n, true
+			}
+			continue
+		}
 		if n, ok := c.ConvertVariable(rego); ok {
-			return n, true
+			return c, n, true
 		}
 	}
-	return nil, false
+	return nil, nil, false
+}
+
+// variableFinder is a matcher made of other matchers, such as a
+// VariableConverter.
+type variableFinder interface {
+	MatchVariable(rego ast.Ref) (VariableMatcher, *Item, bool)
+}
+
+// matchVariable returns the matcher that converted the ref.
+func matchVariable(m VariableMatcher, rego ast.Ref) (VariableMatcher, *Item, bool) {
+	if f, ok := m.(variableFinder); ok {
+		return f.MatchVariable(rego)
+	}
+	n, ok := m.ConvertVariable(rego)
+	return m, n, ok
+}
+
+// DescribeMatcher returns a short description of the matcher, such as
+// 'column owner_id'. Matchers can implement fmt.Stringer to describe
+// themselves, otherwise the type is used.
+func DescribeMatcher(m VariableMatcher) string {
+	if s, ok := m.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", m)
 }
 
 // RegoVarPath will consume the following terms from the given rego Ref and
@@ -86,16 +146,464 @@ func StringVarMatcher(regoPath []string, columnRef []string, val cty.Value) Vari
 func (s astStringVar) ConvertVariable(rego ast.Ref) (*Item, bool) {
 	left, err := RegoVarPath(s.FieldPath, rego)
 	if err == nil && len(left) == 0 {
-		fields := make([]*pg_query.Node, 0, len(s.ColumnString))
-		for _, p := range s.ColumnString {
-			fields = append(fields, pg_query.MakeStrNode(p))
-		}
-
 		return &Item{
-			Node:  pg_query.MakeColumnRefNode(fields, 0),
+			Node:  columnRefNode(s.ColumnString),
 			Value: s.Value,
 		}, true
 	}
 
 	return nil, false
 }
+
+func (s astStringVar) MatchPath() []string {
+	return s.FieldPath
+}
+
+func (s astStringVar) String() string {
+	return "column " + strings.Join(s.ColumnString, ".")
+}
+
+// constVar is any variable that is a constant value for every row.
+type constVar struct {
+	FieldPath []string
+	Value     cty.Value
+}
+
+// ConstVarMatcher matches the rego path to a constant value. This is used for
+// fields that are the same for every row of a table, such as the type of the
+// resource.
+func ConstVarMatcher(regoPath []string, val cty.Value) VariableMatcher {
+	return constVar{
+		FieldPath: regoPath,
+		Value:     val,
+	}
+}
+
+func (c constVar) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	left, err := RegoVarPath(c.FieldPath, rego)
+	if err != nil || len(left) != 0 {
+		return nil, false
+	}
+
+	var node *pg_query.Node
+	switch {
+	case c.Value.IsNull() || !c.Value.IsKnown():
+		return nil, false
+	case c.Value.Type().Equals(cty.String):
+		node = pg_query.MakeAConstStrNode(c.Value.AsString(), 0)
+	case c.Value.Type().Equals(cty.Bool):
+		node = constBoolean(c.Value.True(), 0)
+	case c.Value.Type().Equals(cty.Number):
+		bf := c.Value.AsBigFloat()
+		if i, acc := bf.Int64(); acc == big.Exact {
+			node = pg_query.MakeAConstIntNode(i, 0)
+		} else {
+			node = constFloat(bf.Text('f', -1), 0)
+		}
+	default:
+		return nil, false
+	}
+
+	return &Item{
+		Node:   node,
+		Value:  c.Value,
+		Source: rego.String(),
+	}, true
+}
+
+func (c constVar) MatchPath() []string {
+	return c.FieldPath
+}
+
+func (c constVar) String() string {
+	return "const " + c.Value.GoString()
+}
+
+// castVar casts the result of another matcher.
+type castVar struct {
+	Matcher  VariableMatcher
+	TypeName []string
+}
+
+// CastVarMatcher casts the column of the given matcher to another SQL type.
+// For example, uuid columns can be cast to text so they can be compared to
+// arrays of strings.
+//
+//	CastVarMatcher(m, "text"): organization_id -> organization_id::text
+func CastVarMatcher(m VariableMatcher, typeName ...string) VariableMatcher {
+	return castVar{
+		Matcher:  m,
+		TypeName: typeName,
+	}
+}
+
+func (c castVar) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	item, ok := c.Matcher.ConvertVariable(rego)
+	if !ok {
+		return nil, false
+	}
+	return &Item{
+		Node:   typeCast(item.Node, c.TypeName...),
+		Value:  item.Value,
+		Source: item.Source,
+	}, true
+}
+
+func (c castVar) MatchPath() []string {
+	return matchPath(c.Matcher)
+}
+
+func (c castVar) String() string {
+	return DescribeMatcher(c.Matcher) + "::" + strings.Join(c.TypeName, ".")
+}
+
+// alwaysFalse makes any expression using the variables of another matcher
+// false.
+type alwaysFalse struct {
+	Matcher VariableMatcher
+}
+
+// AlwaysFalseMatcher matches the same variables as the given matcher, but
+// any expression using them is false. A query with a false expression can
+// never be satisfied, so it is dropped. This is used for fields that a table
+// does not have, such as the owner of a resource that is only owned by an
+// organization.
+func AlwaysFalseMatcher(m VariableMatcher) VariableMatcher {
+	return alwaysFalse{Matcher: m}
+}
+
+func (a alwaysFalse) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	item, ok := a.Matcher.ConvertVariable(rego)
+	if !ok {
+		return nil, false
+	}
+	return &Item{
+		Node:   item.Node,
+		Value:  item.Value.Mark(markAlwaysFalse),
+		Source: item.Source,
+	}, true
+}
+
+func (a alwaysFalse) MatchPath() []string {
+	return matchPath(a.Matcher)
+}
+
+func (a alwaysFalse) String() string {
+	return "always false (" + DescribeMatcher(a.Matcher) + ")"
+}
+
+func columnRefNode(columnRef []string) *pg_query.Node {
+	fields := make([]*pg_query.Node, 0, len(columnRef))
+	for _, p := range columnRef {
+		fields = append(fields, pg_query.MakeStrNode(p))
+	}
+	return pg_query.MakeColumnRefNode(fields, 0)
+}
+
+// JSONBPathMatcher matches any rego ref below RegoPath to a path into a JSONB
+// column. Each remaining term of the ref is a key into the JSON document:
+//
+//	input.object.metadata.labels.env      -> metadata #>> ARRAY['labels', 'env']
+//	input.object.settings["feature"].on   -> CAST(settings #>> ARRAY['feature', 'on'] AS boolean)
+//	input.object.settings[input.x].on     -> CAST((settings -> x) ->> 'on' AS boolean)
+//
+// Type describes the shape of the column's document, and is used to find the
+// type of the value at the end of the path. Strings are extracted as text,
+// numbers and booleans are cast, and anything else remains JSONB.
+// cty.DynamicPseudoType can be used for any part of the document with an
+// unknown shape.
+type JSONBPathMatcher struct {
+	RegoPath  []string
+	ColumnRef []string
+	Type      cty.Type
+
+	// FieldReference handles keys that are variables themselves, such as
+	// input.object.settings[input.subject.id].
+	FieldReference VariableMatcher
+}
+
+func NewJSONBPathMatcher(fieldReference VariableMatcher, regoPath []string, columnRef []string, typ cty.Type) JSONBPathMatcher {
+	return JSONBPathMatcher{RegoPath: regoPath, ColumnRef: columnRef, Type: typ, FieldReference: fieldReference}
+}
+
+func (j JSONBPathMatcher) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	left, err := RegoVarPath(j.RegoPath, rego)
+	if err != nil {
+		return nil, false
+	}
+
+	column := columnRefNode(j.ColumnRef)
+	if len(left) == 0 {
+		return &Item{
+			Node:   column,
+			Value:  MarkJSONB(cty.UnknownVal(j.Type)),
+			Source: rego.String(),
+		}, true
+	}
+
+	// A variable key iterates over all elements, see iterate.
+	for i, term := range left {
+		if v, ok := term.Value.(ast.Var); ok {
+			return j.iterate(rego, column, left[:i], v, left[i+1:])
+		}
+	}
+
+	node, typ, ok := j.path(column, j.Type, left)
+	if !ok {
+		return nil, false
+	}
+	return &Item{
+		Node:   node,
+		Value:  j.value(typ),
+		Source: rego.String(),
+	}, true
+}
+
+func (j JSONBPathMatcher) MatchPath() []string {
+	return j.RegoPath
+}
+
+func (j JSONBPathMatcher) String() string {
+	return "jsonb column " + strings.Join(j.ColumnRef, ".")
+}
+
+// iterate converts a ref with a variable key, which iterates over all the
+// elements at that point of the path. The elements are selected as rows in a
+// subquery, and the rest of the path is from the element.
+//
+//	"read" in input.object.acl[_]
+//	 -> EXISTS (SELECT 1 FROM jsonb_each(acl) acl_entry(key, value) WHERE acl_entry.value ? 'read')
+func (j JSONBPathMatcher) iterate(rego ast.Ref, column *pg_query.Node, prefix []*ast.Term, v ast.Var, rest []*ast.Term) (*Item, bool) {
+	for _, term := range rest {
+		// Only a single iteration is supported.
+		if _, ok := term.Value.(ast.Var); ok {
+			return nil, false
+		}
+	}
+
+	collection, typ := column, j.Type
+	if len(prefix) > 0 {
+		var ok bool
+		collection, typ, ok = j.path(column, j.Type, prefix)
+		if !ok || !IsJSONBool(j.value(typ)) {
+			return nil, false
+		}
+	}
+
+	var (
+		object bool
+		elem   cty.Type
+	)
+	switch {
+	case typ.IsMapType() || typ.IsListType():
+		object = typ.IsMapType()
+		elem = typ.ElementType()
+	case typ.IsObjectType():
+		object = true
+		elem, _ = jsonbVariableKeyType(typ, cty.String)
+	default:
+		// The kind of collection must be known.
+		return nil, false
+	}
+
+	elems := &jsonbElements{
+		id:     strings.Join(j.ColumnRef, ".") + ":" + ast.Ref(prefix).String(),
+		name:   j.ColumnRef[len(j.ColumnRef)-1] + "_entry",
+		value:  collection,
+		object: object,
+		text:   len(rest) == 0 && isJSONBPrimitive(elem),
+	}
+	mark := relationMark{source: elems, Var: string(v)}
+
+	node := columnRefNode([]string{elems.name, "value"})
+	if len(rest) == 0 {
+		return &Item{
+			Node:   jsonbCast(node, elem),
+			Value:  j.value(elem).Mark(mark),
+			Source: rego.String(),
+		}, true
+	}
+
+	node, typ, ok := j.path(node, elem, rest)
+	if !ok {
+		return nil, false
+	}
+	return &Item{
+		Node:   node,
+		Value:  j.value(typ).Mark(mark),
+		Source: rego.String(),
+	}, true
+}
+
+// path converts the keys into a path from the JSONB node, of the given type.
+// The type at the end of the path is returned.
+func (j JSONBPathMatcher) path(from *pg_query.Node, typ cty.Type, terms []*ast.Term) (*pg_query.Node, cty.Type, bool) {
+	keys := make([]*pg_query.Node, 0, len(terms))
+	// Constant keys can all be sent as a single path with '#>'.
+	constant := true
+	for _, term := range terms {
+		var key *pg_query.Node
+		switch v := term.Value.(type) {
+		case ast.String:
+			next, ok := jsonbAttributeType(typ, string(v))
+			if !ok {
+				return nil, cty.NilType, false
+			}
+			typ = next
+			key = pg_query.MakeAConstStrNode(string(v), 0)
+		case ast.Number:
+			// Postgres counts negative indexes from the end, in rego they
+			// are undefined.
+			idx, ok := v.Int()
+			if !ok || idx < 0 {
+				return nil, cty.NilType, false
+			}
+			next, ok := jsonbIndexType(typ, idx)
+			if !ok {
+				return nil, cty.NilType, false
+			}
+			typ = next
+			key = pg_query.MakeAConstIntNode(int64(idx), 0)
+		case ast.Ref:
+			if j.FieldReference == nil {
+				return nil, cty.NilType, false
+			}
+			item, ok := j.FieldReference.ConvertVariable(v)
+			if !ok {
+				return nil, cty.NilType, false
+			}
+			next, ok := jsonbVariableKeyType(typ, item.Value.Type())
+			if !ok {
+				return nil, cty.NilType, false
+			}
+			typ = next
+			key = item.Node
+			constant = false
+		default:
+			return nil, cty.NilType, false
+		}
+		keys = append(keys, key)
+	}
+
+	// Primitives are extracted as text, everything else stays as jsonb.
+	asText := isJSONBPrimitive(typ)
+
+	node := from
+	if constant && len(keys) > 1 {
+		path := make([]*pg_query.Node, 0, len(keys))
+		for _, term := range terms {
+			path = append(path, pg_query.MakeAConstStrNode(jsonbPathElement(term), 0))
+		}
+		op := "#>"
+		if asText {
+			op = "#>>"
+		}
+		node = pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+			[]*pg_query.Node{pg_query.MakeStrNode(op)}, from, arrayNode(path), 0)
+	} else {
+		for i, key := range keys {
+			op := "->"
+			if asText && i == len(keys)-1 {
+				op = "->>"
+			}
+			node = pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+				[]*pg_query.Node{pg_query.MakeStrNode(op)}, node, key, 0)
+		}
+	}
+
+	return jsonbCast(node, typ), typ, true
+}
+
+// value is the value of a path of the given type. Anything that is not
+// extracted as text is JSONB.
+func (j JSONBPathMatcher) value(typ cty.Type) cty.Value {
+	if isJSONBPrimitive(typ) {
+		return cty.UnknownVal(typ)
+	}
+	return MarkJSONB(cty.UnknownVal(typ))
+}
+
+func isJSONBPrimitive(typ cty.Type) bool {
+	return typ.Equals(cty.String) || typ.Equals(cty.Number) || typ.Equals(cty.Bool)
+}
+
+// jsonbCast casts the text extracted from a JSONB value to the SQL type of
+// typ.
+func jsonbCast(node *pg_query.Node, typ cty.Type) *pg_query.Node {
+	switch {
+	case typ.Equals(cty.Number):
+		return typeCast(node, "pg_catalog", "numeric")
+	case typ.Equals(cty.Bool):
+		return typeCast(node, "pg_catalog", "bool")
+	}
+	return node
+}
+
+// jsonbAttributeType returns the type of the value at key within typ.
+func jsonbAttributeType(typ cty.Type, key string) (cty.Type, bool) {
+	switch {
+	case typ.Equals(cty.DynamicPseudoType):
+		return cty.DynamicPseudoType, true
+	case typ.IsObjectType():
+		if !typ.HasAttribute(key) {
+			return cty.NilType, false
+		}
+		return typ.AttributeType(key), true
+	case typ.IsMapType():
+		return typ.ElementType(), true
+	}
+	return cty.NilType, false
+}
+
+// jsonbIndexType returns the type of the value at index idx within typ.
+func jsonbIndexType(typ cty.Type, idx int) (cty.Type, bool) {
+	switch {
+	case typ.Equals(cty.DynamicPseudoType):
+		return cty.DynamicPseudoType, true
+	case typ.IsListType():
+		return typ.ElementType(), true
+	case typ.IsTupleType():
+		elems := typ.TupleElementTypes()
+		if idx < 0 || idx >= len(elems) {
+			return cty.NilType, false
+		}
+		return elems[idx], true
+	}
+	return cty.NilType, false
+}
+
+// jsonbVariableKeyType returns the type of the value at an unknown key of
+// type keyType within typ.
+func jsonbVariableKeyType(typ cty.Type, keyType cty.Type) (cty.Type, bool) {
+	switch {
+	case typ.Equals(cty.DynamicPseudoType):
+		return cty.DynamicPseudoType, true
+	case typ.IsMapType() && keyType.Equals(cty.String):
+		return typ.ElementType(), true
+	case typ.IsListType() && keyType.Equals(cty.Number):
+		return typ.ElementType(), true
+	case typ.IsObjectType() && keyType.Equals(cty.String):
+		// The attribute is unknown, so the type is only known if all
+		// attributes share it.
+		var elem cty.Type
+		for _, attr := range typ.AttributeTypes() {
+			if elem != cty.NilType && !elem.Equals(attr) {
+				return cty.DynamicPseudoType, true
+			}
+			elem = attr
+		}
+		if elem == cty.NilType {
+			return cty.DynamicPseudoType, true
+		}
+		return elem, true
+	}
+	return cty.NilType, false
+}
+
+// jsonbPathElement returns the text form of a constant key for a '#>' path.
+func jsonbPathElement(term *ast.Term) string {
+	if s, ok := term.Value.(ast.String); ok {
+		return string(s)
+	}
+	return term.Value.String()
+}
@@ -0,0 +1,406 @@
+package rego2sql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// RelatedTableMatcher matches a rego collection to the rows of a related table
+// that reference the current row. Expressions using the collection are moved
+// into a correlated subquery on that table.
+//
+//	"u1" in input.object.members
+//	 -> EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1')
+//
+// Expressions iterating over the same element of the collection share the
+// same subquery, so they must all hold for the same row.
+//
+//	input.object.members[i].user_id = "u1"; input.object.members[i].role = "admin"
+//	 -> EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = workspaces.id AND m.user_id = 'u1' AND m.role = 'admin')
+type RelatedTableMatcher struct {
+	// input.object.members -> ["input", "object", "members"]
+	RegoPath []string
+
+	// Table is the related table, referenced by Alias in the subquery.
+	Table string
+	Alias string
+	// ForeignKey is the column of the related table that references the
+	// ParentKey column of the current row. The ParentKey should be qualified
+	// with the table name, as the subquery would otherwise resolve it against
+	// the related table.
+	ForeignKey string
+	ParentKey  []string
+
+	// ValueColumn is used when an element of the collection is used as a
+	// value, such as "u1" in input.object.members.
+	ValueColumn string
+	ValueType   cty.Type
+	// Fields maps the fields of an element to columns of the related table.
+	// input.object.members[i].role -> {"role": {Column: "role", Type: cty.String}}
+	Fields map[string]RelatedColumn
+}
+
+type RelatedColumn struct {
+	Column string
+	Type   cty.Type
+}
+
+// relationSource is a set of rows that rego can iterate over, such as a
+// related table. Expressions using it are moved into an EXISTS subquery.
+type relationSource interface {
+	// key identifies the rows, as each reference creates a new source.
+	key() string
+	alias() string
+	fromNode() *pg_query.Node
+	// joinNode correlates the rows with the current row. It can be nil if
+	// the rows are already correlated.
+	joinNode() *pg_query.Node
+}
+
+// relationMark marks values that are from a relationSource. Var is the rego
+// variable iterating over the rows, or empty if the collection itself is
+// referenced.
+type relationMark struct {
+	source relationSource
+	Var    string
+}
+
+func (r *RelatedTableMatcher) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	left, err := RegoVarPath(r.RegoPath, rego)
+	if err != nil {
+		return nil, false
+	}
+
+	if len(left) == 0 {
+		if r.ValueColumn == "" {
+			return nil, false
+		}
+		return &Item{
+			Node:   r.column(r.ValueColumn),
+			Value:  cty.UnknownVal(cty.List(r.ValueType)).Mark(relationMark{source: r}),
+			Source: rego.String(),
+		}, true
+	}
+
+	// Elements can only be referenced by iterating, as rows have no index.
+	v, ok := left[0].Value.(ast.Var)
+	if !ok {
+		return nil, false
+	}
+	mark := relationMark{source: r, Var: string(v)}
+
+	switch len(left) {
+	case 1:
+		if r.ValueColumn == "" {
+			return nil, false
+		}
+		return &Item{
+			Node:   r.column(r.ValueColumn),
+			Value:  cty.UnknownVal(r.ValueType).Mark(mark),
+			Source: rego.String(),
+		}, true
+	case 2:
+		field, ok := left[1].Value.(ast.String)
+		if !ok {
+			return nil, false
+		}
+		col, ok := r.Fields[string(field)]
+		if !ok {
+			return nil, false
+		}
+		return &Item{
+			Node:   r.column(col.Column),
+			Value:  cty.UnknownVal(col.Type).Mark(mark),
+			Source: rego.String(),
+		}, true
+	}
+	return nil, false
+}
+
+func (r *RelatedTableMatcher) MatchPath() []string {
+	return r.RegoPath
+}
+
+func (r *RelatedTableMatcher) String() string {
+	return fmt.Sprintf("related table %s %s", r.Table, r.Alias)
+}
+
+func (r *RelatedTableMatcher) column(name string) *pg_query.Node {
+	return columnRefNode([]string{r.Alias, name})
+}
+
+func (r *RelatedTableMatcher) key() string {
+	return fmt.Sprintf("%p", r)
+}
+
+func (r *RelatedTableMatcher) alias() string {
+	return r.Alias
+}
+
+func (r *RelatedTableMatcher) fromNode() *pg_query.Node {
+	return pg_query.MakeFullRangeVarNode("", r.Table, r.Alias, 0)
+}
+
+func (r *RelatedTableMatcher) joinNode() *pg_query.Node {
+	return pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_OP,
+		[]*pg_query.Node{pg_query.MakeStrNode("=")},
+		r.column(r.ForeignKey), columnRefNode(r.ParentKey), 0,
+	)
+}
+
+// jsonbElements are the elements of a JSONB object or array, as rows.
+type jsonbElements struct {
+	// id identifies the JSONB value the elements are from.
+	id    string
+	name  string
+	value *pg_query.Node
+	// object uses jsonb_each instead of jsonb_array_elements.
+	object bool
+	// text uses the '_text' variant of the functions.
+	text bool
+}
+
+func (j *jsonbElements) key() string {
+	return j.id
+}
+
+func (j *jsonbElements) alias() string {
+	return j.name
+}
+
+// fromNode is 'jsonb_each(value) name(key, value)' for objects, and
+// 'jsonb_array_elements(value) name(value)' for arrays.
+func (j *jsonbElements) fromNode() *pg_query.Node {
+	fn := "jsonb_array_elements"
+	colnames := []*pg_query.Node{pg_query.MakeStrNode("value")}
+	if j.object {
+		fn = "jsonb_each"
+		colnames = append([]*pg_query.Node{pg_query.MakeStrNode("key")}, colnames...)
+	}
+	if j.text {
+		fn += "_text"
+	}
+
+	return &pg_query.Node{
+		Node: &pg_query.Node_RangeFunction{
+			RangeFunction: &pg_query.RangeFunction{
+				Functions: []*pg_query.Node{
+					pg_query.MakeListNode([]*pg_query.Node{funcCall(fn, j.value), {}}),
+				},
+				Alias: &pg_query.Alias{
+					Aliasname: j.name,
+					Colnames:  colnames,
+				},
+			},
+		},
+	}
+}
+
+func (j *jsonbElements) joinNode() *pg_query.Node {
+	return nil
+}
+
+// relationMarks returns all related tables referenced by the value.
+func relationMarks(v cty.Value) []relationMark {
+	var marks []relationMark
+	for m := range v.Marks() {
+		if rm, ok := m.(relationMark); ok {
+			marks = append(marks, rm)
+		}
+	}
+	return marks
+}
+
+// collectionRelation returns the related table if the value is a collection
+// of related rows.
+func collectionRelation(v cty.Value) (relationMark, bool) {
+	for _, m := range relationMarks(v) {
+		if m.Var == "" {
+			return m, true
+		}
+	}
+	return relationMark{}, false
+}
+
+// convertedExpr is an expression of a query body, with the related tables it
+// references.
+type convertedExpr struct {
+	item      *Item
+	relations []relationMark
+}
+
+// groupError is an error of moving an expression into a related table
+// subquery. expr is the index of the expression.
+type groupError struct {
+	expr int
+	err  error
+}
+
+func (e *groupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *groupError) Unwrap() error {
+	return e.err
+}
+
+type relationKey struct {
+	source string
+	Var    string
+	// expr is set for references to the collection itself, as every
+	// membership check is on its own row.
+	expr int
+}
+
+// groupRelations replaces all expressions that reference related tables with
+// EXISTS subqueries. Expressions that share an iteration variable are put in
+// the same subquery. The subquery takes the place of the first expression in
+// the group. The indexes of the expressions each item is from are returned
+// as well.
+func groupRelations(exprs []convertedExpr) ([]*Item, [][]int, error) {
+	// Union find over the expression indexes.
+	parent := make([]int, len(exprs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	keys := make([][]relationKey, len(exprs))
+	sources := make(map[relationKey]relationSource)
+	owners := make(map[relationKey]int)
+	for i, e := range exprs {
+		for _, m := range e.relations {
+			key := relationKey{source: m.source.key(), Var: m.Var, expr: -1}
+			if m.Var == "" {
+				key.expr = i
+			}
+			keys[i] = append(keys[i], key)
+			sources[key] = m.source
+
+			if owner, ok := owners[key]; ok {
+				a, b := find(owner), find(i)
+				// The lowest index is the root, so the group keeps the
+				// position of its first expression.
+				if a > b {
+					a, b = b, a
+				}
+				parent[b] = a
+				continue
+			}
+			owners[key] = i
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range exprs {
+		if len(keys[i]) == 0 {
+			continue
+		}
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+
+	items := make([]*Item, 0, len(exprs))
+	from := make([][]int, 0, len(exprs))
+	for i, e := range exprs {
+		if len(keys[i]) == 0 {
+			items = append(items, e.item)
+			from = append(from, []int{i})
+			continue
+		}
+		members, ok := groups[i]
+		if !ok {
+			// Part of a group that was already added.
+			continue
+		}
+
+		item, err := existsSubquery(exprs, keys, sources, members)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+		from = append(from, members)
+	}
+	return items, from, nil
+}
+
+// existsSubquery builds the subquery for a group of expressions.
+func existsSubquery(exprs []convertedExpr, keys [][]relationKey, sources map[relationKey]relationSource, members []int) (*Item, error) {
+	var (
+		from  []*pg_query.Node
+		where []*pg_query.Node
+		regos []string
+	)
+
+	seen := make(map[relationKey]bool)
+	aliases := make(map[string]bool)
+	for _, i := range members {
+		for _, key := range keys[i] {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			source := sources[key]
+			if aliases[source.alias()] {
+				return nil, &groupError{expr: i, err: fmt.Errorf("%q is iterated more than once in the same expression: %q", source.alias(), exprs[i].item.Source)}
+			}
+			aliases[source.alias()] = true
+
+			from = append(from, source.fromNode())
+			if join := source.joinNode(); join != nil {
+				where = append(where, join)
+			}
+		}
+	}
+
+	for _, i := range members {
+		item := exprs[i].item
+		if item.Value.Type() != cty.Bool {
+			return nil, &groupError{expr: i, err: fmt.Errorf("expected boolean type, got %s for rego %q", item.Value, item.Source)}
+		}
+		where = append(where, item.Node)
+		regos = append(regos, item.Source)
+	}
+
+	return &Item{
+		Node:   existsNode(from, where),
+		Value:  cty.UnknownVal(cty.Bool),
+		Source: strings.Join(regos, "; "),
+	}, nil
+}
+
+// existsNode is 'EXISTS (SELECT 1 FROM from WHERE where)', with the where
+// conditions AND'd.
+func existsNode(from []*pg_query.Node, where []*pg_query.Node) *pg_query.Node {
+	return &pg_query.Node{
+		Node: &pg_query.Node_SubLink{
+			SubLink: &pg_query.SubLink{
+				SubLinkType: pg_query.SubLinkType_EXISTS_SUBLINK,
+				Subselect: &pg_query.Node{
+					Node: &pg_query.Node_SelectStmt{
+						SelectStmt: &pg_query.SelectStmt{
+							TargetList: []*pg_query.Node{
+								pg_query.MakeResTargetNodeWithVal(pg_query.MakeAConstIntNode(1, 0), 0),
+							},
+							FromClause:  from,
+							WhereClause: pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, where, 0),
+							LimitOption: pg_query.LimitOption_LIMIT_OPTION_DEFAULT,
+							Op:          pg_query.SetOperation_SETOP_NONE,
+						},
+					},
+				},
+			},
+		},
+	}
+}
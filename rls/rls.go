@@ -0,0 +1,205 @@
+// Package rls compiles rego policies into postgres row-level security
+// policies, so the database enforces the same rules as the rego policy.
+package rls
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Emyrk/rego2sql"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// Policy is a row-level security policy on a table. The Using and WithCheck
+// rego queries are partially evaluated, and converted into the USING and
+// WITH CHECK expressions of the policy.
+type Policy struct {
+	Name string
+	// Table can be qualified with a schema, such as 'public.workspaces'.
+	Table string
+	// Command is ALL, SELECT, INSERT, UPDATE or DELETE. It defaults to ALL.
+	Command string
+	// Roles the policy applies to. It defaults to PUBLIC.
+	Roles []string
+	// Restrictive policies are AND'd with the other policies, instead of
+	// OR'd.
+	Restrictive bool
+
+	// Using is the rego query existing rows must satisfy, such as
+	// 'data.authz.allow'. It is not allowed for INSERT.
+	Using string
+	// WithCheck is the rego query new rows must satisfy. It is not allowed
+	// for SELECT and DELETE.
+	WithCheck string
+}
+
+type Config struct {
+	// Partial is used to evaluate the Using and WithCheck queries of every
+	// policy. Its Query is ignored. The subject should be one of the
+	// Unknowns, and read from the session with rego2sql.SessionVarMatcher, so
+	// the policies apply to every user.
+	Partial rego2sql.PartialConfig
+	Convert rego2sql.ConvertConfig
+
+	// Force also forces row-level security on the tables, so the policies
+	// apply to the table owner.
+	Force bool
+}
+
+// Generate returns the statements to enable row-level security on the tables,
+// followed by the CREATE POLICY statement of each policy.
+//
+//	ALTER TABLE workspaces ENABLE ROW LEVEL SECURITY
+//	CREATE POLICY workspaces_select ON workspaces FOR SELECT TO public USING (owner_id = NULLIF(current_setting('app.user_id', true), ''))
+func Generate(ctx context.Context, cfg Config, policies ...Policy) ([]string, error) {
+	var (
+		stmts  []*pg_query.Node
+		tables = make(map[string]bool)
+	)
+	for _, p := range policies {
+		if tables[p.Table] {
+			continue
+		}
+		tables[p.Table] = true
+
+		stmts = append(stmts, alterTable(p.Table, pg_query.AlterTableType_AT_EnableRowSecurity))
+		if cfg.Force {
+			stmts = append(stmts, alterTable(p.Table, pg_query.AlterTableType_AT_ForceRowSecurity))
+		}
+	}
+
+	for _, p := range policies {
+		stmt, err := createPolicy(ctx, cfg, p)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", p.Name, err)
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	out := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		sql, err := pg_query.Deparse(&pg_query.ParseResult{
+			Stmts: []*pg_query.RawStmt{{Stmt: stmt}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("deparse: %w", err)
+		}
+		out = append(out, strings.TrimSpace(sql))
+	}
+	return out, nil
+}
+
+func createPolicy(ctx context.Context, cfg Config, p Policy) (*pg_query.Node, error) {
+	if p.Name == "" || p.Table == "" {
+		return nil, fmt.Errorf("name and table are required")
+	}
+
+	command := strings.ToUpper(p.Command)
+	if command == "" {
+		command = "ALL"
+	}
+	switch command {
+	case "ALL", "UPDATE":
+	case "SELECT", "DELETE":
+		if p.WithCheck != "" {
+			return nil, fmt.Errorf("%s policies cannot have a WITH CHECK expression", command)
+		}
+	case "INSERT":
+		if p.Using != "" {
+			return nil, fmt.Errorf("%s policies cannot have a USING expression", command)
+		}
+	default:
+		return nil, fmt.Errorf("unknown command %q", p.Command)
+	}
+	if p.Using == "" && p.WithCheck == "" {
+		return nil, fmt.Errorf("a USING or WITH CHECK query is required")
+	}
+
+	using, err := convertQuery(ctx, cfg, p.Using)
+	if err != nil {
+		return nil, fmt.Errorf("using: %w", err)
+	}
+	check, err := convertQuery(ctx, cfg, p.WithCheck)
+	if err != nil {
+		return nil, fmt.Errorf("with check: %w", err)
+	}
+
+	roles := make([]*pg_query.Node, 0, len(p.Roles))
+	for _, role := range p.Roles {
+		roles = append(roles, roleSpec(role))
+	}
+	if len(roles) == 0 {
+		roles = append(roles, roleSpec("PUBLIC"))
+	}
+
+	return &pg_query.Node{
+		Node: &pg_query.Node_CreatePolicyStmt{
+			CreatePolicyStmt: &pg_query.CreatePolicyStmt{
+				PolicyName: p.Name,
+				Table:      rangeVar(p.Table),
+				CmdName:    strings.ToLower(command),
+				Permissive: !p.Restrictive,
+				Roles:      roles,
+				Qual:       using,
+				WithCheck:  check,
+			},
+		},
+	}, nil
+}
+
+// convertQuery returns nil if the query is empty.
+func convertQuery(ctx context.Context, cfg Config, query string) (*pg_query.Node, error) {
+	if query == "" {
+		return nil, nil
+	}
+
+	partial := cfg.Partial
+	partial.Query = query
+	queries, err := rego2sql.Partial(ctx, partial)
+	if err != nil {
+		return nil, err
+	}
+	return rego2sql.Convert(cfg.Convert, queries)
+}
+
+func alterTable(table string, subtype pg_query.AlterTableType) *pg_query.Node {
+	return &pg_query.Node{
+		Node: &pg_query.Node_AlterTableStmt{
+			AlterTableStmt: &pg_query.AlterTableStmt{
+				Relation: rangeVar(table),
+				Cmds: []*pg_query.Node{
+					{Node: &pg_query.Node_AlterTableCmd{AlterTableCmd: &pg_query.AlterTableCmd{
+						Subtype:  subtype,
+						Behavior: pg_query.DropBehavior_DROP_RESTRICT,
+					}}},
+				},
+				Objtype: pg_query.ObjectType_OBJECT_TABLE,
+			},
+		},
+	}
+}
+
+func rangeVar(table string) *pg_query.RangeVar {
+	schema, name := "", table
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		schema, name = table[:i], table[i+1:]
+	}
+	// MakeFullRangeVar is not used, as the deparser does not handle its
+	// empty alias in DDL.
+	return &pg_query.RangeVar{
+		Schemaname:     schema,
+		Relname:        name,
+		Inh:            true,
+		Relpersistence: "p",
+		Location:       -1,
+	}
+}
+
+func roleSpec(role string) *pg_query.Node {
+	spec := &pg_query.RoleSpec{Roletype: pg_query.RoleSpecType_ROLESPEC_CSTRING, Rolename: role, Location: -1}
+	if strings.EqualFold(role, "public") {
+		spec = &pg_query.RoleSpec{Roletype: pg_query.RoleSpecType_ROLESPEC_PUBLIC, Location: -1}
+	}
+	return &pg_query.Node{Node: &pg_query.Node_RoleSpec{RoleSpec: spec}}
+}
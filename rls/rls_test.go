@@ -0,0 +1,111 @@
+package rls_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/rls"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const policy = `package authz
+
+default allow := false
+
+allow if input.object.owner == input.subject.id
+
+allow if {
+	input.subject.role == "admin"
+	input.object.org_owner == input.subject.org
+}
+
+# Rows can only be created in the org of the subject.
+create if input.object.org_owner == input.subject.org
+`
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	cfg := rls.Config{
+		Partial: rego2sql.PartialConfig{
+			Modules:  map[string]string{"policy.rego": policy},
+			Unknowns: []string{"input.object", "input.subject"},
+		},
+		Convert: rego2sql.ConvertConfig{
+			VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+				rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+				rego2sql.StringVarMatcher([]string{"input", "object", "org_owner"}, []string{"organization_id"}, cty.UnknownVal(cty.String)),
+				rego2sql.SessionVarMatcher([]string{"input", "subject", "id"}, "app.user_id", cty.String),
+				rego2sql.SessionVarMatcher([]string{"input", "subject", "org"}, "app.org_id", cty.String),
+				rego2sql.SessionVarMatcher([]string{"input", "subject", "role"}, "app.role", cty.String),
+			),
+		},
+	}
+
+	t.Run("Policies", func(t *testing.T) {
+		t.Parallel()
+
+		stmts, err := rls.Generate(context.Background(), cfg,
+			rls.Policy{
+				Name:    "workspaces_select",
+				Table:   "public.workspaces",
+				Command: "select",
+				Using:   "data.authz.allow = true",
+			},
+			rls.Policy{
+				Name:        "workspaces_insert",
+				Table:       "public.workspaces",
+				Command:     "INSERT",
+				Roles:       []string{"app_user"},
+				Restrictive: true,
+				WithCheck:   "data.authz.create",
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"ALTER TABLE public.workspaces ENABLE ROW LEVEL SECURITY",
+			"CREATE POLICY workspaces_select ON public.workspaces FOR SELECT TO public USING ((owner_id = (NULLIF(current_setting('app.user_id', true), ''))) OR ((NULLIF(current_setting('app.role', true), '')) = 'admin' AND organization_id = (NULLIF(current_setting('app.org_id', true), ''))))",
+			"CREATE POLICY workspaces_insert ON public.workspaces AS RESTRICTIVE FOR INSERT TO app_user WITH CHECK ((organization_id = (NULLIF(current_setting('app.org_id', true), ''))))",
+		}, stmts)
+
+		for _, stmt := range stmts {
+			_, err := pg_query.Parse(stmt)
+			require.NoError(t, err, "statement is valid: %s", stmt)
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := cfg
+		cfg.Force = true
+		stmts, err := rls.Generate(context.Background(), cfg, rls.Policy{
+			Name:      "workspaces_all",
+			Table:     "workspaces",
+			Using:     "data.authz.allow = true",
+			WithCheck: "data.authz.create",
+		})
+		require.NoError(t, err)
+		require.Len(t, stmts, 3)
+		require.Equal(t, "ALTER TABLE workspaces ENABLE ROW LEVEL SECURITY", stmts[0])
+		require.Equal(t, "ALTER TABLE workspaces FORCE ROW LEVEL SECURITY", stmts[1])
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, p := range []rls.Policy{
+			{Name: "insert_using", Table: "workspaces", Command: "INSERT", Using: "data.authz.allow"},
+			{Name: "select_check", Table: "workspaces", Command: "SELECT", WithCheck: "data.authz.allow"},
+			{Name: "no_queries", Table: "workspaces"},
+			{Name: "unknown_command", Table: "workspaces", Command: "TRUNCATE", Using: "data.authz.allow"},
+			{Name: "", Table: "workspaces", Using: "data.authz.allow"},
+		} {
+			_, err := rls.Generate(context.Background(), cfg, p)
+			require.Error(t, err, p.Name)
+		}
+	})
+}
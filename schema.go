@@ -0,0 +1,515 @@
+package rego2sql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// Schema describes the tables the SQL is run on. If it is set as Validate in
+// the ConvertConfig, the converted SQL is validated against it, so mistakes in the
+// matchers are returned from Convert instead of when the SQL is run:
+//   - Every column must exist in its table.
+//   - Comparisons must be between compatible types, a number column cannot
+//     be compared to a string.
+//   - JSONB operators must be used on JSONB.
+//
+// Types that are not known, such as enums, are not checked.
+type Schema struct {
+	// Tables by name.
+	Tables map[string]Table
+}
+
+type Table struct {
+	// Columns by name.
+	Columns map[string]Column
+}
+
+type Column struct {
+	// Type is the postgres name of the type, without the schema, such as
+	// 'text', 'int4' or 'uuid'.
+	Type  string
+	Array bool
+}
+
+// ParseSchema reads the tables from the CREATE TABLE statements of the DDL.
+// Other statements are ignored.
+func ParseSchema(ddl string) (*Schema, error) {
+	tree, err := pg_query.Parse(ddl)
+	if err != nil {
+		return nil, fmt.Errorf("parse ddl: %w", err)
+	}
+
+	s := &Schema{Tables: make(map[string]Table)}
+	for _, stmt := range tree.Stmts {
+		create := stmt.Stmt.GetCreateStmt()
+		if create == nil {
+			continue
+		}
+
+		table := Table{Columns: make(map[string]Column)}
+		for _, elt := range create.TableElts {
+			def := elt.GetColumnDef()
+			if def == nil {
+				continue
+			}
+			table.Columns[def.Colname] = typeNameColumn(def.TypeName)
+		}
+		s.Tables[create.Relation.Relname] = table
+	}
+	return s, nil
+}
+
+// AddStruct adds a table with the fields of the struct as columns. The column
+// name is the 'db' tag of the field, or the name of the field in snake case.
+// Fields tagged with 'db:"-"' are skipped.
+func (s *Schema) AddStruct(table string, v any) error {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("%T is not a struct", v)
+	}
+
+	if s.Tables == nil {
+		s.Tables = make(map[string]Table)
+	}
+	t := Table{Columns: make(map[string]Column)}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = snakeCase(field.Name)
+		}
+		t.Columns[name] = goTypeColumn(field.Type)
+	}
+	s.Tables[table] = t
+	return nil
+}
+
+func typeNameColumn(typeName *pg_query.TypeName) Column {
+	if typeName == nil || len(typeName.Names) == 0 {
+		return Column{}
+	}
+	name := typeName.Names[len(typeName.Names)-1].GetString_().GetSval()
+	return Column{Type: name, Array: len(typeName.ArrayBounds) > 0}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+func goTypeColumn(typ reflect.Type) Column {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ == timeType:
+		return Column{Type: "timestamptz"}
+	case typ == rawType:
+		return Column{Type: "jsonb"}
+	case typ.Name() == "UUID":
+		// github.com/google/uuid, and most other uuid packages.
+		return Column{Type: "uuid"}
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return Column{Type: "text"}
+	case reflect.Bool:
+		return Column{Type: "bool"}
+	case reflect.Int8, reflect.Int16, reflect.Uint8:
+		return Column{Type: "int2"}
+	case reflect.Int32, reflect.Uint16:
+		return Column{Type: "int4"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return Column{Type: "int8"}
+	case reflect.Float32:
+		return Column{Type: "float4"}
+	case reflect.Float64:
+		return Column{Type: "float8"}
+	case reflect.Map:
+		return Column{Type: "jsonb"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return Column{Type: "bytea"}
+		}
+		elem := goTypeColumn(typ.Elem())
+		if elem.Array || elem.Type == "" {
+			return Column{}
+		}
+		return Column{Type: elem.Type, Array: true}
+	case reflect.Struct:
+		// sql.NullString and similar types.
+		if field, ok := typ.FieldByName("Valid"); ok && field.Type.Kind() == reflect.Bool && typ.NumField() == 2 {
+			return goTypeColumn(typ.Field(0).Type)
+		}
+	}
+	return Column{}
+}
+
+var snakeCaseInitialisms = regexp.MustCompile(`([A-Z]+)([A-Z][a-z])`)
+
+// snakeCase converts a Go field name to snake case, OrganizationID ->
+// organization_id.
+func snakeCase(name string) string {
+	name = snakeCaseInitialisms.ReplaceAllString(name, "${1}_${2}")
+	var sb strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := rune(name[i-1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// typeCategory groups the postgres types that can be compared with each
+// other. An empty category is not checked.
+func typeCategory(typ string) string {
+	switch typ {
+	case "text", "varchar", "bpchar", "char", "name", "citext":
+		return "text"
+	case "int2", "int4", "int8", "smallint", "integer", "bigint", "numeric", "decimal",
+		"float4", "float8", "real", "serial", "bigserial":
+		return "number"
+	case "bool", "boolean":
+		return "bool"
+	case "uuid":
+		return "uuid"
+	case "jsonb":
+		return "jsonb"
+	case "timestamp", "timestamptz", "date", "time", "timetz":
+		return "time"
+	case "inet", "cidr":
+		return "inet"
+	}
+	return ""
+}
+
+// sqlType is the type of an expression of the converted SQL.
+type sqlType struct {
+	category string
+	array    bool
+	// literal is set for string constants, which postgres converts to the
+	// type they are compared to.
+	literal *string
+}
+
+func (t sqlType) known() bool {
+	return t.category != "" || t.literal != nil
+}
+
+func (t sqlType) String() string {
+	name := t.category
+	if t.literal != nil {
+		name = "string"
+	}
+	if t.array {
+		name += "[]"
+	}
+	return name
+}
+
+// schemaValidator validates a converted tree.
+type schemaValidator struct {
+	schema *Schema
+	// table is the table of the unqualified columns.
+	table string
+	// aliases maps the aliases of the tree and config to table names.
+	aliases map[string]string
+	// opaque are the aliases of set returning functions, such as jsonb_each,
+	// whose columns are not checked.
+	opaque map[string]bool
+	errs   []error
+}
+
+func (s *Schema) validate(cfg ConvertConfig, n *pg_query.Node) error {
+	v := &schemaValidator{
+		schema:  s,
+		table:   cfg.ValidateTable,
+		aliases: make(map[string]string),
+		opaque:  make(map[string]bool),
+	}
+	if v.table == "" {
+		v.table = cfg.TableAlias
+	}
+	if v.table == "" {
+		return fmt.Errorf("the table of the unqualified columns is not set, set ValidateTable or TableAlias")
+	}
+	if _, ok := s.Tables[v.table]; !ok {
+		return fmt.Errorf("table %q is not in the schema", v.table)
+	}
+	if cfg.TableAlias != "" {
+		v.aliases[cfg.TableAlias] = v.table
+	}
+	for table, alias := range cfg.TableAliases {
+		v.aliases[alias] = table
+	}
+
+	WalkNodes(n, func(n *pg_query.Node) {
+		if rv := n.GetRangeVar(); rv != nil && rv.Alias != nil && rv.Alias.Aliasname != "" {
+			v.aliases[rv.Alias.Aliasname] = rv.Relname
+		}
+		if rf := n.GetRangeFunction(); rf != nil && rf.Alias != nil {
+			v.opaque[rf.Alias.Aliasname] = true
+		}
+	})
+
+	WalkNodes(n, func(n *pg_query.Node) {
+		switch {
+		case n.GetColumnRef() != nil:
+			v.typeOf(n)
+		case n.GetAExpr() != nil:
+			v.checkExpr(n)
+		}
+	})
+
+	// The order of the walk is deterministic, but errors are sorted so they
+	// are easier to read.
+	sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Error() < v.errs[j].Error() })
+	return errors.Join(dedupeErrors(v.errs)...)
+}
+
+func dedupeErrors(errs []error) []error {
+	deduped := make([]error, 0, len(errs))
+	for i, err := range errs {
+		if i > 0 && errs[i-1].Error() == err.Error() {
+			continue
+		}
+		deduped = append(deduped, err)
+	}
+	return deduped
+}
+
+func (v *schemaValidator) errorf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+// column returns the type of the column ref. An error is recorded if the
+// column does not exist.
+func (v *schemaValidator) column(ref *pg_query.ColumnRef) sqlType {
+	names := make([]string, 0, len(ref.Fields))
+	for _, f := range ref.Fields {
+		s := f.GetString_()
+		if s == nil {
+			// 'table.*'
+			return sqlType{}
+		}
+		names = append(names, s.Sval)
+	}
+	qualified := strings.Join(names, ".")
+
+	if len(names) == 1 {
+		if col, ok := v.schema.Tables[v.table].Columns[names[0]]; ok {
+			return columnType(col)
+		}
+		v.errorf("column %q does not exist in table %q", qualified, v.table)
+		return sqlType{}
+	}
+
+	// [schema.]table.column
+	tableName, colName := names[len(names)-2], names[len(names)-1]
+	if v.opaque[tableName] {
+		return sqlType{}
+	}
+	if aliased, ok := v.aliases[tableName]; ok {
+		tableName = aliased
+	}
+	table, ok := v.schema.Tables[tableName]
+	if !ok {
+		v.errorf("column %q: table %q is not in the schema", qualified, tableName)
+		return sqlType{}
+	}
+	col, ok := table.Columns[colName]
+	if !ok {
+		v.errorf("column %q does not exist in table %q", qualified, tableName)
+		return sqlType{}
+	}
+	return columnType(col)
+}
+
+func columnType(col Column) sqlType {
+	return sqlType{category: typeCategory(col.Type), array: col.Array}
+}
+
+// typeOf returns the type of the expression, which is not known for most
+// function calls.
+func (v *schemaValidator) typeOf(n *pg_query.Node) sqlType {
+	switch {
+	case n.GetColumnRef() != nil:
+		return v.column(n.GetColumnRef())
+	case n.GetTypeCast() != nil:
+		cast := n.GetTypeCast()
+		v.typeOf(cast.Arg)
+		return columnType(typeNameColumn(cast.TypeName))
+	case n.GetAConst() != nil:
+		c := n.GetAConst()
+		switch {
+		case c.GetSval() != nil:
+			s := c.GetSval().Sval
+			return sqlType{literal: &s}
+		case c.GetIval() != nil, c.GetFval() != nil:
+			return sqlType{category: "number"}
+		case c.GetBoolval() != nil:
+			return sqlType{category: "bool"}
+		}
+	case n.GetAArrayExpr() != nil:
+		elems := n.GetAArrayExpr().Elements
+		if len(elems) == 0 {
+			return sqlType{}
+		}
+		elem := v.typeOf(elems[0])
+		if elem.array {
+			return sqlType{}
+		}
+		elem.array = true
+		return elem
+	case n.GetFuncCall() != nil:
+		names := n.GetFuncCall().Funcname
+		switch names[len(names)-1].GetString_().GetSval() {
+		case "jsonb_build_array", "jsonb_build_object":
+			return sqlType{category: "jsonb"}
+		case "current_setting":
+			return sqlType{category: "text"}
+		case "string_to_array":
+			return sqlType{category: "text", array: true}
+		case "cardinality", "char_length", "jsonb_array_length":
+			return sqlType{category: "number"}
+		}
+	case n.GetAExpr() != nil:
+		switch exprOperator(n.GetAExpr()) {
+		case "->", "#>":
+			return sqlType{category: "jsonb"}
+		case "->>", "#>>":
+			return sqlType{category: "text"}
+		}
+		return sqlType{category: "bool"}
+	case n.GetBoolExpr() != nil, n.GetNullTest() != nil, n.GetSubLink() != nil:
+		return sqlType{category: "bool"}
+	}
+	return sqlType{}
+}
+
+func exprOperator(expr *pg_query.A_Expr) string {
+	if len(expr.Name) == 0 {
+		return ""
+	}
+	return expr.Name[len(expr.Name)-1].GetString_().GetSval()
+}
+
+// checkExpr type checks the operands of an operator.
+func (v *schemaValidator) checkExpr(n *pg_query.Node) {
+	expr := n.GetAExpr()
+	op := exprOperator(expr)
+	left, right := sqlType{}, sqlType{}
+	if expr.Lexpr != nil {
+		left = v.typeOf(expr.Lexpr)
+	}
+	if expr.Rexpr != nil {
+		right = v.typeOf(expr.Rexpr)
+	}
+
+	describe := func() string {
+		sql, err := Serialize(n)
+		if err != nil {
+			return op
+		}
+		return sql
+	}
+
+	switch op {
+	case "?", "?|", "?&", "@>", "<@", "->", "->>", "#>", "#>>":
+		if left.known() && left.category != "jsonb" {
+			v.errorf("%s: operator %s expects jsonb, got %s", describe(), op, left)
+		}
+		return
+	case "=", "<>", "!=", "<", ">", "<=", ">=":
+	default:
+		return
+	}
+
+	if expr.Kind == pg_query.A_Expr_Kind_AEXPR_OP_ANY {
+		if !right.known() {
+			return
+		}
+		if !right.array {
+			v.errorf("%s: ANY expects an array, got %s", describe(), right)
+			return
+		}
+		right.array = false
+	}
+
+	if !typesComparable(left, right) {
+		v.errorf("%s: cannot compare %s to %s", describe(), left, right)
+	}
+}
+
+// typesComparable returns true if postgres can compare the two types. Unknown
+// types are always comparable.
+func typesComparable(a, b sqlType) bool {
+	if a.literal != nil && b.literal != nil {
+		return true
+	}
+	if b.literal != nil {
+		a, b = b, a
+	}
+	if a.literal != nil {
+		if b.category == "" {
+			return true
+		}
+		if b.array {
+			return false
+		}
+		return literalCompatible(*a.literal, b.category)
+	}
+
+	if a.category == "" || b.category == "" {
+		return true
+	}
+	return a.category == b.category && a.array == b.array
+}
+
+var uuidRegex = regexp.MustCompile(`^\{?[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}\}?$`)
+
+// literalCompatible returns true if postgres can convert the string to the
+// category.
+func literalCompatible(s string, category string) bool {
+	switch category {
+	case "number":
+		_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return err == nil
+	case "uuid":
+		return uuidRegex.MatchString(strings.TrimSpace(s))
+	case "bool":
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "t", "true", "y", "yes", "on", "1", "f", "false", "n", "no", "off", "0":
+			return true
+		}
+		return false
+	case "jsonb":
+		return json.Valid([]byte(s))
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package rego2sql_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const schemaDDL = `
+CREATE TABLE workspaces (
+	id uuid PRIMARY KEY,
+	owner_id uuid NOT NULL,
+	organization_id uuid NOT NULL,
+	name varchar(64) NOT NULL,
+	ttl bigint,
+	tags text[],
+	group_acl jsonb NOT NULL DEFAULT '{}',
+	user_acl jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE workspace_members (
+	workspace_id uuid NOT NULL REFERENCES workspaces (id),
+	user_id uuid NOT NULL,
+	role text NOT NULL
+);
+
+CREATE INDEX workspaces_owner_id ON workspaces (owner_id);
+`
+
+func TestValidateSchema(t *testing.T) {
+	t.Parallel()
+
+	schema, err := rego2sql.ParseSchema(schemaDDL)
+	require.NoError(t, err)
+	require.Equal(t, rego2sql.Column{Type: "int8"}, schema.Tables["workspaces"].Columns["ttl"])
+	require.Equal(t, rego2sql.Column{Type: "text", Array: true}, schema.Tables["workspaces"].Columns["tags"])
+
+	str := func(path, column string) rego2sql.VariableMatcher {
+		return rego2sql.StringVarMatcher([]string{"input", "object", path}, []string{column}, cty.UnknownVal(cty.String))
+	}
+	num := func(path, column string) rego2sql.VariableMatcher {
+		return rego2sql.StringVarMatcher([]string{"input", "object", path}, []string{column}, cty.UnknownVal(cty.Number))
+	}
+	members := &rego2sql.RelatedTableMatcher{
+		RegoPath:    []string{"input", "object", "members"},
+		Table:       "workspace_members",
+		Alias:       "m",
+		ForeignKey:  "workspace_id",
+		ParentKey:   []string{"w", "id"},
+		ValueColumn: "user_id",
+		ValueType:   cty.String,
+		Fields: map[string]rego2sql.RelatedColumn{
+			"role":  {Column: "role", Type: cty.String},
+			"level": {Column: "level", Type: cty.Number},
+		},
+	}
+
+	testCases := []struct {
+		Name          string
+		Queries       []string
+		Matchers      []rego2sql.VariableMatcher
+		Converter     *rego2sql.VariableConverter
+		NoTableAlias  bool
+		ExpectedError string
+	}{
+		{
+			Name: "Workspaces",
+			Queries: []string{
+				`input.object.owner = "1f2a7c3e-2b5d-4e8f-9a0b-1c2d3e4f5a6b"`,
+				`"read" in input.object.acl_group_list[_]`,
+				`"read" in input.object.acl_user_list.me`,
+			},
+			Converter: codercfg.WorkspaceConverter(),
+		},
+		{
+			Name:    "Valid",
+			Queries: []string{`input.object.name = "dev"; input.object.ttl > 60; "prod" in input.object.tags; input.object.ttl_s != "3600"`},
+			Matchers: []rego2sql.VariableMatcher{
+				str("name", "name"), num("ttl", "ttl"), str("ttl_s", "ttl"),
+				rego2sql.StringVarMatcher([]string{"input", "object", "tags"}, []string{"tags"}, cty.UnknownVal(cty.List(cty.String))),
+			},
+		},
+		{
+			Name:     "Related",
+			Queries:  []string{`"1f2a7c3e-2b5d-4e8f-9a0b-1c2d3e4f5a6b" in input.object.members`, `input.object.members[_].role = "admin"`},
+			Matchers: []rego2sql.VariableMatcher{members},
+		},
+		{
+			Name:          "MissingColumn",
+			Queries:       []string{`input.object.name = "dev"`},
+			Matchers:      []rego2sql.VariableMatcher{str("name", "nmae")},
+			ExpectedError: `schema: column "w.nmae" does not exist in table "workspaces"`,
+		},
+		{
+			Name:          "MissingRelatedColumn",
+			Queries:       []string{`input.object.members[_].level > 1`},
+			Matchers:      []rego2sql.VariableMatcher{members},
+			ExpectedError: `schema: column "m.level" does not exist in table "workspace_members"`,
+		},
+		{
+			// Unqualified columns are of the validated table, even if a
+			// related table has a column of the same name.
+			Name:    "UnqualifiedRelatedColumn",
+			Queries: []string{`input.object.members[_].role = "admin"; input.object.role = "admin"`},
+			Matchers: []rego2sql.VariableMatcher{
+				&rego2sql.RelatedTableMatcher{
+					RegoPath:   members.RegoPath,
+					Table:      members.Table,
+					Alias:      members.Alias,
+					ForeignKey: members.ForeignKey,
+					ParentKey:  []string{"workspaces", "id"},
+					Fields:     members.Fields,
+				},
+				str("role", "role"),
+			},
+			NoTableAlias:  true,
+			ExpectedError: `schema: column "role" does not exist in table "workspaces"`,
+		},
+		{
+			Name:          "NumberToString",
+			Queries:       []string{`input.object.ttl = "1h"`},
+			Matchers:      []rego2sql.VariableMatcher{str("ttl", "ttl")},
+			ExpectedError: `schema: w.ttl = '1h': cannot compare number to string`,
+		},
+		{
+			Name:          "StringToNumber",
+			Queries:       []string{`input.object.name > 5`},
+			Matchers:      []rego2sql.VariableMatcher{num("name", "name")},
+			ExpectedError: `schema: w.name > 5: cannot compare text to number`,
+		},
+		{
+			Name:          "UUIDToString",
+			Queries:       []string{`"u1" in input.object.members`},
+			Matchers:      []rego2sql.VariableMatcher{members},
+			ExpectedError: `schema: m.user_id = 'u1': cannot compare uuid to string`,
+		},
+		{
+			Name:          "NotJSONB",
+			Queries:       []string{`"read" in input.object.acl.me`},
+			Matchers:      []rego2sql.VariableMatcher{rego2sql.NewJSONBPathMatcher(nil, []string{"input", "object", "acl"}, []string{"name"}, cty.Map(cty.List(cty.String)))},
+			ExpectedError: `schema: w.name -> 'me': operator -> expects jsonb, got text`,
+		},
+		{
+			Name:     "MultipleErrors",
+			Queries:  []string{`input.object.name = "dev"`, `input.object.ttl = "1h"`},
+			Matchers: []rego2sql.VariableMatcher{str("name", "nmae"), str("ttl", "ttl")},
+			ExpectedError: "schema: column \"w.nmae\" does not exist in table \"workspaces\"\n" +
+				"w.ttl = '1h': cannot compare number to string",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			converter := tc.Converter
+			if converter == nil {
+				converter = rego2sql.NewVariableConverter().RegisterMatcher(tc.Matchers...)
+			}
+			cfg := rego2sql.ConvertConfig{
+				VariableConverter: converter,
+				TableAlias:        "w",
+				Validate:          schema,
+				ValidateTable:     "workspaces",
+			}
+			if tc.NoTableAlias {
+				cfg.TableAlias = ""
+			}
+			part := partialQueries(t, tc.Queries...)
+			_, err := rego2sql.Convert(cfg, part.Queries)
+			if tc.ExpectedError != "" {
+				require.EqualError(t, err, tc.ExpectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSchemaAddStruct(t *testing.T) {
+	t.Parallel()
+
+	type Workspace struct {
+		ID             [16]byte
+		OwnerID        string
+		OrganizationID string
+		TTL            sql.NullInt64 `db:"ttl_ms"`
+		Tags           []string
+		UserACL        json.RawMessage
+		CreatedAt      time.Time
+		Ignored        string `db:"-"`
+		unexported     string
+	}
+
+	var schema rego2sql.Schema
+	require.NoError(t, schema.AddStruct("workspaces", &Workspace{}))
+	require.Equal(t, rego2sql.Table{Columns: map[string]rego2sql.Column{
+		"id":              {Type: "bytea"},
+		"owner_id":        {Type: "text"},
+		"organization_id": {Type: "text"},
+		"ttl_ms":          {Type: "int8"},
+		"tags":            {Type: "text", Array: true},
+		"user_acl":        {Type: "jsonb"},
+		"created_at":      {Type: "timestamptz"},
+	}}, schema.Tables["workspaces"])
+
+	require.Error(t, schema.AddStruct("workspaces", "not a struct"))
+}
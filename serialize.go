@@ -47,7 +47,11 @@ func Serialize(n *pg_query.Node) (string, error) {
 		return "", fmt.Errorf("deparse: %w", err)
 	}
 
-	withoutSelect := strings.TrimPrefix(dsql, "SELECT WHERE")
-	// TODO: It would be best to find the args and pass them in as parameters, not strings.
-	return strings.TrimSpace(withoutSelect), nil
+	// The deparsed statement is 'SELECT WHERE <expr>'. Use SerializeParams
+	// for parameters, or InjectStatement for a complete statement.
+	expr, ok := strings.CutPrefix(dsql, "SELECT WHERE ")
+	if !ok {
+		return "", fmt.Errorf("unexpected deparsed statement %q", dsql)
+	}
+	return strings.TrimSpace(expr), nil
 }
@@ -0,0 +1,137 @@
+package rego2sql_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestSerializeParams(t *testing.T) {
+	t.Parallel()
+
+	workspaces := rego2sql.ConvertConfig{VariableConverter: codercfg.WorkspaceConverter()}
+
+	testCases := []struct {
+		Name         string
+		Queries      []string
+		Config       rego2sql.ConvertConfig
+		ExpectedSQL  string
+		ExpectedArgs []any
+		// ExpectedSqlizer is the output of ToSql.
+		ExpectedSqlizer     string
+		ExpectedSqlizerArgs []any
+	}{
+		{
+			Name: "Strings",
+			Queries: []string{
+				`"org1" = input.object.org_owner`,
+				`input.object.owner = "user1"`,
+			},
+			Config:              workspaces,
+			ExpectedSQL:         "($1 = organization_id::text) OR (owner_id::text = $2)",
+			ExpectedArgs:        []any{"org1", "user1"},
+			ExpectedSqlizer:     "(? = organization_id::text) OR (owner_id::text = ?)",
+			ExpectedSqlizerArgs: []any{"org1", "user1"},
+		},
+		{
+			Name: "JSONBOperators",
+			Queries: []string{
+				`"read" in input.object.acl_user_list.user1`,
+			},
+			Config:              workspaces,
+			ExpectedSQL:         "((user_acl -> $1::text) ? $2::text)",
+			ExpectedArgs:        []any{"user1", "read"},
+			ExpectedSqlizer:     "((user_acl -> ?::text) ?? ?::text)",
+			ExpectedSqlizerArgs: []any{"user1", "read"},
+		},
+		{
+			Name: "Numbers",
+			Queries: []string{
+				`input.object.count > 5; input.object.count < 7.5`,
+			},
+			Config: rego2sql.ConvertConfig{
+				VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+					rego2sql.StringVarMatcher([]string{"input", "object", "count"}, []string{"count"}, cty.UnknownVal(cty.Number)),
+				),
+			},
+			ExpectedSQL:         "(count > $1 AND count < $2)",
+			ExpectedArgs:        []any{int64(5), 7.5},
+			ExpectedSqlizer:     "(count > ? AND count < ?)",
+			ExpectedSqlizerArgs: []any{int64(5), 7.5},
+		},
+		{
+			Name:            "Booleans",
+			Queries:         []string{`input.object.any_org = true`},
+			Config:          workspaces,
+			ExpectedSQL:     "(false = true)",
+			ExpectedSqlizer: "(false = true)",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			part := partialQueries(t, tc.Queries...)
+			node, err := rego2sql.Convert(tc.Config, part.Queries)
+			require.NoError(t, err, "convert")
+
+			sql, args, err := rego2sql.SerializeParams(node)
+			require.NoError(t, err, "serialize")
+			require.Equal(t, tc.ExpectedSQL, sql, "sql match")
+			require.Equal(t, tc.ExpectedArgs, args, "args match")
+
+			sql, args, err = rego2sql.Sqlizer{Node: node}.ToSql()
+			require.NoError(t, err, "sqlizer")
+			require.Equal(t, tc.ExpectedSqlizer, sql, "sqlizer match")
+			require.Equal(t, tc.ExpectedSqlizerArgs, args, "sqlizer args match")
+		})
+	}
+}
+
+func TestInjectFilter(t *testing.T) {
+	t.Parallel()
+
+	part := partialQueries(t, `"org1" = input.object.org_owner`, `input.object.owner = "user1"`)
+	node, err := rego2sql.Convert(rego2sql.ConvertConfig{VariableConverter: codercfg.WorkspaceConverter()}, part.Queries)
+	require.NoError(t, err, "convert")
+
+	t.Run("Renumber", func(t *testing.T) {
+		t.Parallel()
+
+		query := `-- name: GetWorkspaces :many
+SELECT * FROM workspaces
+WHERE deleted = $1 AND name != '$2 -- @authorize_filter'
+-- @authorize_filter
+LIMIT $2;`
+		args := []any{false, 10}
+
+		sql, sqlArgs, err := rego2sql.InjectFilter(query, args, node)
+		require.NoError(t, err)
+		require.Equal(t, `-- name: GetWorkspaces :many
+SELECT * FROM workspaces
+WHERE deleted = $1 AND name != '$2 -- @authorize_filter'
+AND (($3 = organization_id::text) OR (owner_id::text = $4))
+LIMIT $2;`, sql)
+		require.Equal(t, []any{false, 10, "org1", "user1"}, sqlArgs)
+		require.Len(t, args, 2, "args are not modified")
+	})
+
+	t.Run("NoPlaceholder", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := rego2sql.InjectFilter("SELECT * FROM workspaces WHERE deleted = $1", []any{false}, node)
+		require.ErrorContains(t, err, rego2sql.FilterPlaceholder)
+	})
+
+	t.Run("MissingArgs", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := rego2sql.InjectFilter("SELECT * FROM workspaces WHERE deleted = $2\n-- @authorize_filter", []any{false}, node)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,205 @@
+// Package server exposes the conversion over HTTP, with a compile endpoint
+// that accepts the request of OPA's Compile API.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+type Config struct {
+	// Modules maps file names to rego source.
+	Modules     map[string]string
+	RegoVersion ast.RegoVersion
+	Convert     rego2sql.ConvertConfig
+	// Input is used for requests without an input.
+	Input any
+	// Unknowns are used for requests without unknowns.
+	Unknowns []string
+	// Logger logs every request. Nothing is logged if it is nil.
+	Logger *log.Logger
+	// MaxRequestBytes is the largest request body accepted, 1 MiB by
+	// default.
+	MaxRequestBytes int64
+}
+
+const defaultMaxRequestBytes = 1 << 20
+
+// CompileRequest is the request of OPA's Compile API.
+type CompileRequest struct {
+	Query    string   `json:"query"`
+	Input    any      `json:"input,omitempty"`
+	Unknowns []string `json:"unknowns,omitempty"`
+}
+
+type CompileResponse struct {
+	Result CompileResult `json:"result"`
+}
+
+type CompileResult struct {
+	// Queries are the partially evaluated rego queries.
+	Queries []string `json:"queries"`
+	SQL     string   `json:"sql"`
+	// SQLParams is the SQL with the constants as parameters.
+	SQLParams string             `json:"sql_params"`
+	Args      []any              `json:"args"`
+	Dropped   []rego2sql.Dropped `json:"dropped"`
+	Warnings  []string           `json:"warnings"`
+}
+
+// Error is the error response of OPA's API.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	codeInvalidParameter = "invalid_parameter"
+	codeInternal         = "internal_error"
+	codeMethodNotAllowed = "method_not_allowed"
+)
+
+// New returns the handler of the server:
+//
+//	POST /v1/compile  Partially evaluate the query, and convert it to SQL.
+//	GET  /health      Returns 200 if the server is up.
+//
+// The modules are compiled once, so an error is returned if they are
+// invalid.
+func New(cfg Config) (http.Handler, error) {
+	if cfg.MaxRequestBytes <= 0 {
+		cfg.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	policy, err := rego2sql.PreparePolicy(cfg.Modules, cfg.RegoVersion)
+	if err != nil {
+		return nil, fmt.Errorf("compile modules: %w", err)
+	}
+	s := &server{cfg: cfg, policy: policy}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/compile", s.compile)
+	mux.HandleFunc("/health", s.health)
+	return s.logRequests(mux), nil
+}
+
+type server struct {
+	cfg    Config
+	policy *rego2sql.PreparedPolicy
+}
+
+func (s *server) compile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req CompileRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.cfg.MaxRequestBytes))
+	// Numbers are kept as json.Number, so rego sees the exact value.
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		status := http.StatusBadRequest
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, status, codeInvalidParameter, fmt.Sprintf("decode request: %s", err))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "missing required 'query' value")
+		return
+	}
+
+	input, unknowns := req.Input, req.Unknowns
+	if input == nil {
+		input = s.cfg.Input
+	}
+	if len(unknowns) == 0 {
+		unknowns = s.cfg.Unknowns
+	}
+
+	queries, err := s.policy.Partial(r.Context(), req.Query, input, unknowns)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	node, diags, err := rego2sql.ConvertWithDiagnostics(s.cfg.Convert, queries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("convert: %s", err))
+		return
+	}
+
+	result := CompileResult{
+		Queries:  make([]string, 0, len(queries)),
+		Args:     []any{},
+		Dropped:  append([]rego2sql.Dropped{}, diags.Dropped...),
+		Warnings: append([]string{}, diags.Warnings...),
+	}
+	for _, q := range queries {
+		result.Queries = append(result.Queries, q.String())
+	}
+	result.SQL, err = rego2sql.Serialize(node)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("serialize: %s", err))
+		return
+	}
+	var args []any
+	result.SQLParams, args, err = rego2sql.SerializeParams(node)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("serialize: %s", err))
+		return
+	}
+	result.Args = append(result.Args, args...)
+
+	writeJSON(w, http.StatusOK, CompileResponse{Result: result})
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// statusRecorder records the status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *server) logRequests(next http.Handler) http.Handler {
+	if s.cfg.Logger == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		s.cfg.Logger.Printf("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start))
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string, msg string) {
+	writeJSON(w, status, Error{Code: code, Message: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,190 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/Emyrk/rego2sql/codercfg"
+	"github.com/Emyrk/rego2sql/server"
+	"github.com/stretchr/testify/require"
+)
+
+const policy = `package authz
+
+allow if input.object.owner == input.subject.id
+
+allow if {
+	input.subject.admin
+	input.object.org_owner in input.subject.orgs
+}
+`
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	handler, err := server.New(server.Config{
+		Modules:         map[string]string{"policy.rego": policy},
+		Convert:         rego2sql.ConvertConfig{VariableConverter: codercfg.WorkspaceConverter()},
+		Unknowns:        []string{"input.object"},
+		Logger:          log.New(&logs, "", 0),
+		MaxRequestBytes: 1024,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name           string
+		Method         string
+		Path           string
+		Body           string
+		ExpectedStatus int
+		ExpectedResult *server.CompileResult
+		ExpectedCode   string
+	}{
+		{
+			Name:   "Compile",
+			Method: http.MethodPost,
+			Path:   "/v1/compile",
+			Body: `{
+				"query": "data.authz.allow == true",
+				"input": {"subject": {"id": "u1", "admin": true, "orgs": ["o1"]}},
+				"unknowns": ["input.object"]
+			}`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedResult: &server.CompileResult{
+				Queries: []string{
+					`"u1" = input.object.owner`,
+					`internal.member_2(input.object.org_owner, ["o1"])`,
+				},
+				SQL:       "('u1' = owner_id::text) OR (organization_id::text = ANY(ARRAY['o1']))",
+				SQLParams: "($1 = owner_id::text) OR (organization_id::text = ANY(ARRAY[$2]))",
+				Args:      []any{"u1", "o1"},
+				Dropped:   []rego2sql.Dropped{},
+				Warnings:  []string{},
+			},
+		},
+		{
+			Name:           "DefaultUnknowns",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{"query": "data.authz.allow == true", "input": {"subject": {"id": "u1"}}}`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedResult: &server.CompileResult{
+				Queries:   []string{`"u1" = input.object.owner`},
+				SQL:       "('u1' = owner_id::text)",
+				SQLParams: "($1 = owner_id::text)",
+				Args:      []any{"u1"},
+				Dropped:   []rego2sql.Dropped{},
+				Warnings:  []string{},
+			},
+		},
+		{
+			Name:           "MissingQuery",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{"input": {}}`,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_parameter",
+		},
+		{
+			Name:           "InvalidJSON",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{`,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_parameter",
+		},
+		{
+			Name:           "TooLarge",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{"query": "data.authz.allow == true", "input": {"pad": "` + strings.Repeat("x", 1024) + `"}}`,
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+			ExpectedCode:   "invalid_parameter",
+		},
+		{
+			Name:           "InvalidQuery",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{"query": "data.authz.allow =="}`,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_parameter",
+		},
+		{
+			Name:           "Unconvertible",
+			Method:         http.MethodPost,
+			Path:           "/v1/compile",
+			Body:           `{"query": "startswith(input.object.owner, \"u\")"}`,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_parameter",
+		},
+		{
+			Name:           "CompileGet",
+			Method:         http.MethodGet,
+			Path:           "/v1/compile",
+			ExpectedStatus: http.StatusMethodNotAllowed,
+			ExpectedCode:   "method_not_allowed",
+		},
+		{
+			Name:           "Health",
+			Method:         http.MethodGet,
+			Path:           "/health",
+			ExpectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(tc.Body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.ExpectedStatus, rec.Code, rec.Body.String())
+
+			if tc.ExpectedResult != nil {
+				var resp server.CompileResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+				require.Equal(t, *tc.ExpectedResult, resp.Result)
+			}
+			if tc.ExpectedCode != "" {
+				var resp server.Error
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+				require.Equal(t, tc.ExpectedCode, resp.Code)
+				require.NotEmpty(t, resp.Message)
+			}
+		})
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	handler, err := server.New(server.Config{Logger: log.New(&logs, "", 0)})
+	require.NoError(t, err)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/compile", nil))
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], "GET /health 200 "), lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "GET /v1/compile 405 "), lines[1])
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := server.New(server.Config{
+		Modules: map[string]string{"policy.rego": "package authz\n\nallow if {"},
+	})
+	require.ErrorContains(t, err, "compile modules")
+}
@@ -0,0 +1,94 @@
+package rego2sql
+
+import (
+	"github.com/open-policy-agent/opa/v1/ast"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// sessionVar reads a variable from a postgres setting of the session.
+type sessionVar struct {
+	FieldPath []string
+	Setting   string
+	Type      cty.Type
+}
+
+// SessionVarMatcher matches the rego path to a setting of the session, such
+// as one set with 'SET app.user_id = ...' or 'set_config'. This leaves the
+// subject unknown during partial evaluation, so the SQL is the same for every
+// user, and can be used in row-level security policies and prepared
+// statements.
+//
+//	SessionVarMatcher(path, "app.user_id", cty.String): input.subject.id -> NULLIF(current_setting('app.user_id', true), '')
+//	SessionVarMatcher(path, "app.groups", cty.List(cty.String)): input.subject.groups -> string_to_array(NULLIF(current_setting('app.groups', true), ''), ',')
+//
+// Numbers and booleans are cast to numeric and boolean. Lists of strings or
+// numbers are read from a comma separated setting. Any other type
+// is not matched. An unset setting is NULL, so any comparison with it is
+// false. A setting that was set and then reset, such as by 'SET LOCAL' or on
+// a pooled connection, is an empty string instead, which is read as NULL as
+// well.
+func SessionVarMatcher(regoPath []string, setting string, typ cty.Type) VariableMatcher {
+	return sessionVar{
+		FieldPath: regoPath,
+		Setting:   setting,
+		Type:      typ,
+	}
+}
+
+func (s sessionVar) ConvertVariable(rego ast.Ref) (*Item, bool) {
+	left, err := RegoVarPath(s.FieldPath, rego)
+	if err != nil || len(left) != 0 {
+		return nil, false
+	}
+
+	node, ok := s.node()
+	if !ok {
+		return nil, false
+	}
+	return &Item{
+		Node:   node,
+		Value:  cty.UnknownVal(s.Type),
+		Source: rego.String(),
+	}, true
+}
+
+func (s sessionVar) MatchPath() []string {
+	return s.FieldPath
+}
+
+func (s sessionVar) String() string {
+	return "session setting " + s.Setting
+}
+
+func (s sessionVar) node() (*pg_query.Node, bool) {
+	// missing_ok is set, so an unset setting is NULL rather than an error. A
+	// reset setting is '', which would not fail the comparisons, and is not
+	// a valid number or boolean to cast.
+	setting := pg_query.MakeAExprNode(pg_query.A_Expr_Kind_AEXPR_NULLIF,
+		[]*pg_query.Node{pg_query.MakeStrNode("=")},
+		funcCall("current_setting",
+			pg_query.MakeAConstStrNode(s.Setting, 0),
+			constBoolean(true, 0),
+		),
+		pg_query.MakeAConstStrNode("", 0), 0,
+	)
+
+	switch {
+	case s.Type.Equals(cty.String):
+		return setting, true
+	case s.Type.Equals(cty.Number):
+		return typeCast(setting, "pg_catalog", "numeric"), true
+	case s.Type.Equals(cty.Bool):
+		return typeCast(setting, "pg_catalog", "bool"), true
+	case s.Type.IsListType():
+		list := funcCall("string_to_array", setting, pg_query.MakeAConstStrNode(",", 0))
+		switch {
+		case s.Type.ElementType().Equals(cty.String):
+			return list, true
+		case s.Type.ElementType().Equals(cty.Number):
+			return arrayTypeCast(list, "pg_catalog", "numeric"), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,56 @@
+package rego2sql_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	sqlite "github.com/glebarez/go-sqlite"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// TestSessionVarResetSetting runs the SQL of session settings on SQLite, with
+// every setting reset. current_setting returns an empty string for a setting
+// that was set and then reset in the session, which must not match rows with
+// an empty column.
+func TestSessionVarResetSetting(t *testing.T) {
+	t.Parallel()
+
+	// SQLite has no settings, so the function returns what postgres does
+	// for a reset setting.
+	require.NoError(t, sqlite.RegisterScalarFunction("current_setting", 2, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return "", nil
+	}))
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE workspaces (owner TEXT, level INTEGER)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO workspaces VALUES ('', 0), ('u1', 1)`)
+	require.NoError(t, err)
+
+	vc := rego2sql.NewVariableConverter().RegisterMatcher(
+		rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+		rego2sql.StringVarMatcher([]string{"input", "object", "level"}, []string{"level"}, cty.UnknownVal(cty.Number)),
+		rego2sql.SessionVarMatcher([]string{"input", "subject", "id"}, "app.user_id", cty.String),
+		rego2sql.SessionVarMatcher([]string{"input", "subject", "level"}, "app.level", cty.Number),
+	)
+	for _, query := range []string{
+		`input.object.owner = input.subject.id`,
+		`input.object.level <= input.subject.level`,
+	} {
+		node, err := rego2sql.Convert(rego2sql.ConvertConfig{VariableConverter: vc}, partialQueries(t, query).Queries)
+		require.NoError(t, err)
+		filter, err := rego2sql.Serialize(node)
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT count(*) FROM workspaces WHERE "+filter).Scan(&count))
+		require.Zero(t, count, filter)
+	}
+}
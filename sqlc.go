@@ -0,0 +1,67 @@
+package rego2sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// FilterPlaceholder is the comment in sqlc queries that InjectFilter replaces
+// with the filter. For example:
+//
+//	-- name: GetAuthorizedWorkspaces :many
+//	SELECT * FROM workspaces
+//	WHERE deleted = false
+//	-- @authorize_filter
+//	;
+const FilterPlaceholder = "-- @authorize_filter"
+
+// InjectFilter replaces every FilterPlaceholder comment of a sqlc generated
+// query with 'AND (<filter>)'. The parameters of the filter are numbered after
+// the parameters already in the query, and appended to args. An error is
+// returned if the query has no placeholder, as the query would not be
+// filtered.
+func InjectFilter(query string, args []any, filter *pg_query.Node) (string, []any, error) {
+	var (
+		placeholders []int
+		maxParam     int
+	)
+	err := scanSQL(query, func(tok sqlToken, start, end int) {
+		switch tok {
+		case tokenComment:
+			if strings.TrimSpace(query[start:end]) == FilterPlaceholder {
+				placeholders = append(placeholders, start)
+			}
+		case tokenParam:
+			n, _ := strconv.Atoi(query[start+1 : end])
+			maxParam = max(maxParam, n)
+		}
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("scan query: %w", err)
+	}
+	if len(placeholders) == 0 {
+		return "", nil, fmt.Errorf("query does not contain %q", FilterPlaceholder)
+	}
+	if maxParam > len(args) {
+		return "", nil, fmt.Errorf("query uses $%d, but only %d args are given", maxParam, len(args))
+	}
+
+	sql, filterArgs, err := serializeParams(filter, len(args))
+	if err != nil {
+		return "", nil, fmt.Errorf("serialize filter: %w", err)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, start := range placeholders {
+		out.WriteString(query[last:start])
+		out.WriteString("AND (" + sql + ")")
+		last = start + len(FilterPlaceholder)
+	}
+	out.WriteString(query[last:])
+
+	return out.String(), append(args[:len(args):len(args)], filterArgs...), nil
+}
@@ -0,0 +1,69 @@
+package rego2sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// Sqlizer is a converted node that implements squirrel.Sqlizer, so it can be
+// used as a condition with squirrel's builders:
+//
+//	sq.Select("*").From("workspaces").Where(rego2sql.Sqlizer{Node: node}).
+//		PlaceholderFormat(sq.Dollar)
+//
+// Parameters use squirrel's '?' placeholders. Postgres operators that contain
+// a '?', like the JSONB '?' and '?|', are escaped as '??', which squirrel
+// turns back into a single '?' when it replaces the placeholders. This means
+// the sq.Dollar placeholder format must be used.
+type Sqlizer struct {
+	Node *pg_query.Node
+}
+
+func (s Sqlizer) ToSql() (string, []any, error) {
+	sql, args, err := SerializeParams(s.Node)
+	if err != nil {
+		return "", nil, err
+	}
+	return questionPlaceholders(sql, args)
+}
+
+// questionPlaceholders replaces the '$n' parameters of the SQL with '?', and
+// orders the args by where they appear. Existing question marks are escaped
+// as '??'.
+func questionPlaceholders(sql string, args []any) (string, []any, error) {
+	var (
+		out     strings.Builder
+		ordered []any
+		last    int
+	)
+
+	err := scanSQL(sql, func(tok sqlToken, start, end int) {
+		switch tok {
+		case tokenQuestion:
+			out.WriteString(sql[last:end])
+			out.WriteString("?")
+		case tokenParam:
+			out.WriteString(sql[last:start])
+			out.WriteString("?")
+			// The number was parsed by scanSQL, so it is always valid.
+			n, _ := strconv.Atoi(sql[start+1 : end])
+			if n < 1 || n > len(args) {
+				ordered = append(ordered, nil)
+			} else {
+				ordered = append(ordered, args[n-1])
+			}
+		default:
+			return
+		}
+		last = end
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("scan sql: %w", err)
+	}
+	out.WriteString(sql[last:])
+
+	return out.String(), ordered, nil
+}
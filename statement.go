@@ -0,0 +1,270 @@
+package rego2sql
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+)
+
+// InjectStatement parses the SQL statement and ANDs the filter with the WHERE
+// clause of the SELECT, UPDATE or DELETE statements that read the table.
+//
+//	InjectStatement("SELECT * FROM workspaces w WHERE w.deleted = false", "workspaces", filter)
+//	 -> SELECT * FROM workspaces w WHERE w.deleted = false AND w.owner_id = 'u1'
+//
+// Subqueries and CTEs are searched as well, so the filter is applied where
+// the table is read rather than to the outer statement. If the table is read
+// more than once, such as in a self join, each reference is filtered. If the
+// table is on the nullable side of a LEFT or RIGHT JOIN, the filter is added
+// to the ON clause of the join instead, so the rows of the other table are
+// kept. Tables in a FULL JOIN, or an outer join without an ON clause, are not
+// supported.
+// Unqualified columns of the filter are qualified with the alias of the
+// table, and columns qualified with the table name are renamed to the alias.
+// The table can be qualified with a schema, 'public.workspaces', to only
+// match that schema.
+//
+// If the table is empty, the filter is added to the top level statement
+// as is.
+func InjectStatement(sql string, table string, filter *pg_query.Node) (string, error) {
+	tree, err := parseStatement(sql)
+	if err != nil {
+		return "", err
+	}
+	if err := injectTree(tree, table, filter); err != nil {
+		return "", err
+	}
+	return deparseStatement(tree)
+}
+
+// InjectStatementParams is like InjectStatement, but the constants of the
+// filter are replaced with parameters, numbered after the parameters already
+// in the statement. The filter args are appended to args.
+func InjectStatementParams(sql string, args []any, table string, filter *pg_query.Node) (string, []any, error) {
+	tree, err := parseStatement(sql)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var maxParam int
+	WalkNodes(tree, func(n *pg_query.Node) {
+		if p := n.GetParamRef(); p != nil {
+			maxParam = max(maxParam, int(p.Number))
+		}
+	})
+	if maxParam > len(args) {
+		return "", nil, fmt.Errorf("statement uses $%d, but only %d args are given", maxParam, len(args))
+	}
+
+	filter, filterArgs := parameterize(filter, len(args))
+	if err := injectTree(tree, table, filter); err != nil {
+		return "", nil, err
+	}
+	out, err := deparseStatement(tree)
+	if err != nil {
+		return "", nil, err
+	}
+	return out, append(args[:len(args):len(args)], filterArgs...), nil
+}
+
+func parseStatement(sql string) (*pg_query.ParseResult, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, fmt.Errorf("expected 1 statement, got %d", len(tree.Stmts))
+	}
+	return tree, nil
+}
+
+func deparseStatement(tree *pg_query.ParseResult) (string, error) {
+	out, err := pg_query.Deparse(tree)
+	if err != nil {
+		return "", fmt.Errorf("deparse: %w", err)
+	}
+	return out, nil
+}
+
+// filterTarget is a clause the filter is added to, with the alias the table
+// is referenced by.
+type filterTarget struct {
+	where **pg_query.Node
+	alias string
+}
+
+func injectTree(tree *pg_query.ParseResult, table string, filter *pg_query.Node) error {
+	stmt := tree.Stmts[0].Stmt
+
+	if table == "" {
+		where, err := statementWhere(stmt)
+		if err != nil {
+			return err
+		}
+		andWhere(where, proto.Clone(filter).(*pg_query.Node))
+		return nil
+	}
+
+	schema, name := "", table
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		schema, name = table[:i], table[i+1:]
+	}
+
+	// References to a CTE with the same name as the table are not the table.
+	ctes := make(map[string]bool)
+	WalkNodes(stmt, func(n *pg_query.Node) {
+		if cte := n.GetCommonTableExpr(); cte != nil {
+			ctes[cte.Ctename] = true
+		}
+	})
+
+	// The targets are found before any filter is added, as the filter can
+	// contain subqueries of its own.
+	var (
+		targets []filterTarget
+		err     error
+	)
+	var visit func(n *pg_query.Node)
+	visit = func(n *pg_query.Node) {
+		if s := n.GetSelectStmt(); s != nil && s.Op != pg_query.SetOperation_SETOP_NONE {
+			// The selects of a set operation are not wrapped in nodes, so
+			// they are not visited by WalkNodes.
+			visit(&pg_query.Node{Node: &pg_query.Node_SelectStmt{SelectStmt: s.Larg}})
+			visit(&pg_query.Node{Node: &pg_query.Node_SelectStmt{SelectStmt: s.Rarg}})
+			return
+		}
+
+		where, from := statementFrom(n)
+		if where == nil {
+			return
+		}
+		for _, ref := range tableRefs(from, where, nil) {
+			rv := ref.rv
+			if rv.Relname != name || (schema != "" && rv.Schemaname != schema) {
+				continue
+			}
+			if rv.Schemaname == "" && ctes[rv.Relname] {
+				continue
+			}
+			if ref.err != nil && err == nil {
+				err = fmt.Errorf("table %q: %w", table, ref.err)
+			}
+			alias := rv.Relname
+			if rv.Alias != nil {
+				alias = rv.Alias.Aliasname
+			}
+			targets = append(targets, filterTarget{where: ref.where, alias: alias})
+		}
+	}
+	WalkNodes(stmt, visit)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("table %q is not read by the statement", table)
+	}
+
+	for _, t := range targets {
+		cfg := ConvertConfig{TableAlias: t.alias}
+		if t.alias != name {
+			cfg.TableAliases = map[string]string{name: t.alias}
+		}
+		andWhere(t.where, qualifyColumns(cfg, filter))
+	}
+	return nil
+}
+
+// statementWhere returns the WHERE clause of a top level statement.
+func statementWhere(stmt *pg_query.Node) (**pg_query.Node, error) {
+	if s := stmt.GetSelectStmt(); s != nil && s.Op != pg_query.SetOperation_SETOP_NONE {
+		return nil, fmt.Errorf("%s of selects is not supported, specify the table to filter", strings.ToLower(strings.TrimPrefix(s.Op.String(), "SETOP_")))
+	}
+	where, _ := statementFrom(stmt)
+	if where == nil {
+		return nil, fmt.Errorf("expected a SELECT, UPDATE or DELETE statement, got %T", stmt.Node)
+	}
+	return where, nil
+}
+
+// statementFrom returns the WHERE clause and the tables read by a SELECT,
+// UPDATE or DELETE statement. The WHERE clause is nil for any other node.
+func statementFrom(n *pg_query.Node) (**pg_query.Node, []*pg_query.Node) {
+	switch {
+	case n.GetSelectStmt() != nil:
+		s := n.GetSelectStmt()
+		if s.Op != pg_query.SetOperation_SETOP_NONE {
+			return nil, nil
+		}
+		return &s.WhereClause, s.FromClause
+	case n.GetUpdateStmt() != nil:
+		s := n.GetUpdateStmt()
+		return &s.WhereClause, append([]*pg_query.Node{{Node: &pg_query.Node_RangeVar{RangeVar: s.Relation}}}, s.FromClause...)
+	case n.GetDeleteStmt() != nil:
+		s := n.GetDeleteStmt()
+		return &s.WhereClause, append([]*pg_query.Node{{Node: &pg_query.Node_RangeVar{RangeVar: s.Relation}}}, s.UsingClause...)
+	}
+	return nil, nil
+}
+
+// tableRef is a table of a FROM clause, with the clause a filter on the table
+// is added to.
+type tableRef struct {
+	rv    *pg_query.RangeVar
+	where **pg_query.Node
+	// err is set if the table cannot be filtered.
+	err error
+}
+
+// tableRefs returns the tables of a FROM clause, including joined tables.
+// Subqueries are not included, as their statements are visited on their own.
+//
+// Filtering a table in the WHERE clause would drop the rows an outer join
+// keeps when the table has no matching row, turning it into an inner join.
+// Tables on the nullable side of an outer join are filtered in the ON clause
+// of the join instead.
+func tableRefs(from []*pg_query.Node, where **pg_query.Node, err error) []tableRef {
+	var refs []tableRef
+	for _, n := range from {
+		switch {
+		case n.GetRangeVar() != nil:
+			refs = append(refs, tableRef{rv: n.GetRangeVar(), where: where, err: err})
+		case n.GetJoinExpr() != nil:
+			join := n.GetJoinExpr()
+			lwhere, lerr := where, err
+			rwhere, rerr := where, err
+			switch join.Jointype {
+			case pg_query.JoinType_JOIN_LEFT:
+				rwhere, rerr = joinOn(join)
+			case pg_query.JoinType_JOIN_RIGHT:
+				lwhere, lerr = joinOn(join)
+			case pg_query.JoinType_JOIN_FULL:
+				// Both sides are kept, so rows cannot be removed from
+				// either side.
+				lerr = fmt.Errorf("a full join cannot be filtered")
+				rerr = lerr
+			}
+			refs = append(refs, tableRefs([]*pg_query.Node{join.Larg}, lwhere, lerr)...)
+			refs = append(refs, tableRefs([]*pg_query.Node{join.Rarg}, rwhere, rerr)...)
+		}
+	}
+	return refs
+}
+
+// joinOn returns the ON clause of an outer join.
+func joinOn(join *pg_query.JoinExpr) (**pg_query.Node, error) {
+	if join.IsNatural || len(join.UsingClause) > 0 {
+		return nil, fmt.Errorf("an outer join without an ON clause cannot be filtered")
+	}
+	return &join.Quals, nil
+}
+
+// andWhere ANDs the filter with the existing WHERE clause.
+func andWhere(where **pg_query.Node, filter *pg_query.Node) {
+	if *where == nil {
+		*where = filter
+		return
+	}
+	*where = pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, []*pg_query.Node{*where, filter}, 0)
+}
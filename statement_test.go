@@ -0,0 +1,198 @@
+package rego2sql_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestInjectStatement(t *testing.T) {
+	t.Parallel()
+
+	part := partialQueries(t, `input.object.owner = "u1"`)
+	filter, err := rego2sql.Convert(rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		),
+	}, part.Queries)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name        string
+		SQL         string
+		Table       string
+		ExpectedSQL string
+		ExpectError bool
+	}{
+		{
+			Name:        "Select",
+			SQL:         "SELECT * FROM workspaces",
+			ExpectedSQL: "SELECT * FROM workspaces WHERE (owner_id = 'u1')",
+		},
+		{
+			Name:        "SelectWhere",
+			SQL:         "SELECT * FROM workspaces WHERE deleted = false OR name = 'foo'",
+			ExpectedSQL: "SELECT * FROM workspaces WHERE (deleted = false OR name = 'foo') AND ((owner_id = 'u1'))",
+		},
+		{
+			Name:        "Update",
+			SQL:         "UPDATE workspaces SET name = 'bar' WHERE id = $1",
+			ExpectedSQL: "UPDATE workspaces SET name = 'bar' WHERE id = $1 AND ((owner_id = 'u1'))",
+		},
+		{
+			Name:        "Delete",
+			SQL:         "DELETE FROM workspaces",
+			ExpectedSQL: "DELETE FROM workspaces WHERE (owner_id = 'u1')",
+		},
+		{
+			Name:        "Table",
+			SQL:         "SELECT * FROM workspaces",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM workspaces WHERE (workspaces.owner_id = 'u1')",
+		},
+		{
+			Name:        "TableAlias",
+			SQL:         "SELECT * FROM users u JOIN workspaces w ON w.owner_id = u.id WHERE u.deleted = false",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM users u JOIN workspaces w ON w.owner_id = u.id WHERE u.deleted = false AND ((w.owner_id = 'u1'))",
+		},
+		{
+			Name:        "LeftJoin",
+			SQL:         "SELECT * FROM users u LEFT JOIN workspaces w ON w.owner_id = u.id WHERE u.deleted = false",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM users u LEFT JOIN workspaces w ON w.owner_id = u.id AND ((w.owner_id = 'u1')) WHERE u.deleted = false",
+		},
+		{
+			Name:        "LeftJoinPreserved",
+			SQL:         "SELECT * FROM workspaces w LEFT JOIN users u ON w.owner_id = u.id",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM workspaces w LEFT JOIN users u ON w.owner_id = u.id WHERE (w.owner_id = 'u1')",
+		},
+		{
+			Name:        "RightJoin",
+			SQL:         "SELECT * FROM workspaces w RIGHT JOIN users u ON w.owner_id = u.id",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM workspaces w RIGHT JOIN users u ON w.owner_id = u.id AND ((w.owner_id = 'u1'))",
+		},
+		{
+			Name:        "LeftJoinNested",
+			SQL:         "SELECT * FROM users u LEFT JOIN (workspaces w JOIN templates t ON t.id = w.template_id) ON w.owner_id = u.id",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM users u LEFT JOIN (workspaces w JOIN templates t ON t.id = w.template_id) ON w.owner_id = u.id AND ((w.owner_id = 'u1'))",
+		},
+		{
+			Name:        "FullJoin",
+			SQL:         "SELECT * FROM users u FULL JOIN workspaces w ON w.owner_id = u.id",
+			Table:       "workspaces",
+			ExpectError: true,
+		},
+		{
+			Name:        "LeftJoinUsing",
+			SQL:         "SELECT * FROM users LEFT JOIN workspaces USING (id)",
+			Table:       "workspaces",
+			ExpectError: true,
+		},
+		{
+			Name:        "SelfJoin",
+			SQL:         "SELECT * FROM workspaces a, workspaces b",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM workspaces a, workspaces b WHERE ((a.owner_id = 'u1')) AND ((b.owner_id = 'u1'))",
+		},
+		{
+			Name:        "Schema",
+			SQL:         "SELECT * FROM public.workspaces JOIN other.workspaces o ON true",
+			Table:       "public.workspaces",
+			ExpectedSQL: "SELECT * FROM public.workspaces JOIN other.workspaces o ON true WHERE (workspaces.owner_id = 'u1')",
+		},
+		{
+			Name:        "Subquery",
+			SQL:         "SELECT count(*) FROM (SELECT * FROM workspaces WHERE deleted = false) sub",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT count(*) FROM (SELECT * FROM workspaces WHERE deleted = false AND ((workspaces.owner_id = 'u1'))) sub",
+		},
+		{
+			Name:        "SubqueryExpression",
+			SQL:         "SELECT * FROM users WHERE id IN (SELECT owner_id FROM workspaces)",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT * FROM users WHERE id IN (SELECT owner_id FROM workspaces WHERE (workspaces.owner_id = 'u1'))",
+		},
+		{
+			Name:        "CTE",
+			SQL:         "WITH workspaces AS (SELECT * FROM workspaces WHERE deleted = false) SELECT * FROM workspaces",
+			Table:       "workspaces",
+			ExpectedSQL: "WITH workspaces AS (SELECT * FROM workspaces WHERE deleted = false) SELECT * FROM workspaces",
+			// The CTE shadows the table, so the table is never read.
+			ExpectError: true,
+		},
+		{
+			Name:        "CTERenamed",
+			SQL:         "WITH active AS (SELECT * FROM workspaces WHERE deleted = false) SELECT * FROM active",
+			Table:       "workspaces",
+			ExpectedSQL: "WITH active AS (SELECT * FROM workspaces WHERE deleted = false AND ((workspaces.owner_id = 'u1'))) SELECT * FROM active",
+		},
+		{
+			Name:        "Union",
+			SQL:         "SELECT id FROM workspaces UNION SELECT id FROM templates",
+			Table:       "workspaces",
+			ExpectedSQL: "SELECT id FROM workspaces WHERE (workspaces.owner_id = 'u1') UNION SELECT id FROM templates",
+		},
+		{
+			Name:        "UnionNoTable",
+			SQL:         "SELECT id FROM workspaces UNION SELECT id FROM templates",
+			ExpectError: true,
+		},
+		{
+			Name:        "TableNotRead",
+			SQL:         "SELECT * FROM users",
+			Table:       "workspaces",
+			ExpectError: true,
+		},
+		{
+			Name:        "Insert",
+			SQL:         "INSERT INTO workspaces (id) VALUES ('w1')",
+			ExpectError: true,
+		},
+		{
+			Name:        "MultipleStatements",
+			SQL:         "SELECT 1; SELECT 2",
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			sql, err := rego2sql.InjectStatement(tc.SQL, tc.Table, filter)
+			if tc.ExpectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.ExpectedSQL, sql)
+		})
+	}
+}
+
+func TestInjectStatementParams(t *testing.T) {
+	t.Parallel()
+
+	part := partialQueries(t, `input.object.owner = "u1"`)
+	filter, err := rego2sql.Convert(rego2sql.ConvertConfig{
+		VariableConverter: rego2sql.NewVariableConverter().RegisterMatcher(
+			rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner_id"}, cty.UnknownVal(cty.String)),
+		),
+	}, part.Queries)
+	require.NoError(t, err)
+
+	sql, args, err := rego2sql.InjectStatementParams("UPDATE workspaces w SET name = $2 WHERE id = $1", []any{"w1", "bar"}, "workspaces", filter)
+	require.NoError(t, err)
+	require.Equal(t, "UPDATE workspaces w SET name = $2 WHERE id = $1 AND ((w.owner_id = $3))", sql)
+	require.Equal(t, []any{"w1", "bar", "u1"}, args)
+
+	_, _, err = rego2sql.InjectStatementParams("SELECT * FROM workspaces WHERE id = $1", nil, "", filter)
+	require.Error(t, err)
+}
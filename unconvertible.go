@@ -0,0 +1,111 @@
+package rego2sql
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Unconvertible is an expression that cannot be converted.
+type Unconvertible struct {
+	// Query is the index of the query the expression is from.
+	Query    int
+	Rego     string
+	Location *ast.Location
+	// Construct is what could not be converted. It is the construct of an
+	// UnsupportedError, 'ref' for refs no matcher converts, 'related table'
+	// for expressions that cannot be moved into a related table subquery, or
+	// the builtin of the expression for other errors.
+	Construct string
+	Err       error
+}
+
+// FindUnconvertible converts each expression of the queries, and returns the
+// expressions that cannot be converted. Unlike Convert, it does not stop at
+// the first error, so every unsupported part of a policy can be found at once.
+// Expressions that are always false are not returned.
+func FindUnconvertible(cfg ConvertConfig, queries []ast.Body) []Unconvertible {
+	var found []Unconvertible
+	for i, q := range queries {
+		crv := &converter{
+			cfg:   cfg,
+			stack: newStack[*Item](),
+			diags: &Diagnostics{},
+			query: i,
+		}
+		add := func(expr *ast.Expr, err error) {
+			found = append(found, Unconvertible{
+				Query:     i,
+				Rego:      expr.String(),
+				Location:  expr.Location,
+				Construct: unconvertibleConstruct(expr, err),
+				Err:       err,
+			})
+		}
+
+		var (
+			exprs []convertedExpr
+			// from is the index in the query of each converted expression.
+			from []int
+		)
+		bindingExprs := crv.bindVariables(q)
+		for j, expr := range q {
+			if bindingExprs[j] {
+				continue
+			}
+
+			crv.relations = nil
+			item, err := crv.convertExpr(expr)
+			if err == nil && item != nil && item.Value.Type() != cty.Bool {
+				err = fmt.Errorf("expected boolean type, got %s", item.Value.Type().FriendlyName())
+			}
+			if err == nil {
+				if item != nil {
+					exprs = append(exprs, convertedExpr{item: item, relations: crv.relations})
+					from = append(from, j)
+				}
+				continue
+			}
+			if crv.isFalse(err) {
+				continue
+			}
+			add(expr, err)
+		}
+
+		// Expressions on related tables can only be converted together, as
+		// they are moved into the same subquery. The expression that cannot
+		// be grouped is removed until the rest can be.
+		for {
+			_, _, err := groupRelations(exprs)
+			var grouping *groupError
+			if !errors.As(err, &grouping) {
+				break
+			}
+			add(q[from[grouping.expr]], err)
+			exprs = slices.Delete(exprs, grouping.expr, grouping.expr+1)
+			from = slices.Delete(from, grouping.expr, grouping.expr+1)
+		}
+	}
+	return found
+}
+
+func unconvertibleConstruct(expr *ast.Expr, err error) string {
+	var unsupported *UnsupportedError
+	if errors.As(err, &unsupported) {
+		return unsupported.Construct
+	}
+	if errors.Is(err, errUnknownVariable) {
+		return "ref"
+	}
+	var grouping *groupError
+	if errors.As(err, &grouping) {
+		return "related table"
+	}
+	if expr.IsCall() {
+		return expr.Operator().String()
+	}
+	return "term"
+}
@@ -0,0 +1,62 @@
+package rego2sql_test
+
+import (
+	"testing"
+
+	"github.com/Emyrk/rego2sql"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestFindUnconvertible(t *testing.T) {
+	t.Parallel()
+
+	vc := rego2sql.NewVariableConverter().RegisterMatcher(
+		rego2sql.StringVarMatcher([]string{"input", "object", "owner"}, []string{"owner"}, cty.UnknownVal(cty.String)),
+		&rego2sql.RelatedTableMatcher{
+			RegoPath:   []string{"input", "object", "members"},
+			Table:      "workspace_members",
+			Alias:      "m",
+			ForeignKey: "workspace_id",
+			ParentKey:  []string{"workspaces", "id"},
+			Fields: map[string]rego2sql.RelatedColumn{
+				"user_id": {Column: "user_id", Type: cty.String},
+				"role":    {Column: "role", Type: cty.String},
+			},
+		},
+	)
+
+	part := partialQueries(t,
+		`input.object.owner = "u1"; startswith(input.object.owner, "u")`,
+		// Each expression converts on its own, but the members cannot be
+		// iterated twice in the same subquery.
+		`input.object.members[i].role = "admin"; input.object.members[i].user_id = input.object.members[j].user_id`,
+		`input.object.members[i].role = "admin"; input.object.members[i].user_id = "u1"`,
+	)
+	found := rego2sql.FindUnconvertible(rego2sql.ConvertConfig{VariableConverter: vc}, part.Queries)
+
+	type result struct {
+		Query     int
+		Rego      string
+		Construct string
+		Err       string
+	}
+	results := make([]result, 0, len(found))
+	for _, u := range found {
+		results = append(results, result{Query: u.Query, Rego: u.Rego, Construct: u.Construct, Err: u.Err.Error()})
+	}
+	require.Equal(t, []result{
+		{
+			Query:     0,
+			Rego:      `startswith(input.object.owner, "u")`,
+			Construct: "startswith",
+			Err:       `convert call startswith(input.object.owner, "u"): 1:28: startswith is not supported`,
+		},
+		{
+			Query:     1,
+			Rego:      `input.object.members[i].user_id = input.object.members[j].user_id`,
+			Construct: "related table",
+			Err:       `"m" is iterated more than once in the same expression: "eq(input.object.members[i].user_id, input.object.members[j].user_id)"`,
+		},
+	}, results)
+}
@@ -0,0 +1,39 @@
+package rego2sql
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WalkNodes calls fn for every node in the tree, including the root. fn is
+// called before the children of the node are walked, so changes made by fn to
+// a node are walked as well. The pg_query ast has hundreds of node types, so
+// the protobuf reflection is used to find the children instead of a type
+// switch.
+func WalkNodes(root proto.Message, fn func(n *pg_query.Node)) {
+	walkMessage(root.ProtoReflect(), fn)
+}
+
+func walkMessage(m protoreflect.Message, fn func(n *pg_query.Node)) {
+	if n, ok := m.Interface().(*pg_query.Node); ok {
+		fn(n)
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil || fd.IsMap() {
+			return true
+		}
+
+		if fd.IsList() {
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				walkMessage(list.Get(i).Message(), fn)
+			}
+			return true
+		}
+
+		walkMessage(v.Message(), fn)
+		return true
+	})
+}